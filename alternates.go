@@ -0,0 +1,66 @@
+package sitemap
+
+// Alternate is an alternate version of an entry, encoded as an xhtml:link element of the url block
+// (e.g. a translation, or an AMP page).
+type Alternate struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr,omitempty"`
+	Href     string `xml:"href,attr"` // full url
+}
+
+// HreflangAlternate returns the alternate of an entry in the language lang (e.g. "fr", "de-CH" or "x-default").
+func HreflangAlternate(lang, href string) *Alternate {
+	return &Alternate{Rel: "alternate", Hreflang: lang, Href: href}
+}
+
+// AMPAlternate returns the AMP version of an entry.
+func AMPAlternate(href string) *Alternate {
+	return &Alternate{Rel: "amphtml", Href: href}
+}
+
+// AlternatesFunc returns the alternates of an entry, see Options.Alternates.
+type AlternatesFunc func(e *Entry) []*Alternate
+
+// AMPSuffix returns an AlternatesFunc for sites whose pages have an AMP version at their location followed by suffix
+// (e.g. "/amp" or "?amp=1").
+func AMPSuffix(suffix string) AlternatesFunc {
+	return func(e *Entry) []*Alternate {
+		return []*Alternate{AMPAlternate(e.Location + suffix)}
+	}
+}
+
+// XHTMLSitemapSchema is the XML schema used for sitemaps with alternates (SitemapSchema with the xhtml namespace).
+var XHTMLSitemapSchema = &Schema{
+	Xmlns:             SitemapSchema.Xmlns,
+	XmlnsXsi:          SitemapSchema.XmlnsXsi,
+	XsiSchemaLocation: SitemapSchema.XsiSchemaLocation,
+	XmlnsXhtml:        "http://www.w3.org/1999/xhtml",
+}
+
+// withXHTML returns a copy of s with the xhtml namespace.
+func withXHTML(s *Schema) *Schema {
+	if s == SitemapSchema {
+		return XHTMLSitemapSchema
+	}
+	xhtml := *s
+	xhtml.XmlnsXhtml = XHTMLSitemapSchema.XmlnsXhtml
+	return &xhtml
+}
+
+// addAlternates replaces the alternates of e by a new slice with the alternates of o appended,
+// all copied with their hrefs converted to URIs. The alternates of the source are left untouched.
+func (o *Options) addAlternates(e *Entry) {
+	alternates := e.Alternates
+	if o.Alternates != nil {
+		alternates = append(alternates[:len(alternates):len(alternates)], o.Alternates(e)...)
+	}
+	if len(alternates) == 0 {
+		return
+	}
+	e.Alternates = make([]*Alternate, len(alternates))
+	for i, a := range alternates {
+		c := *a
+		c.Href = EscapeLoc(c.Href)
+		e.Alternates[i] = &c
+	}
+}
@@ -0,0 +1,102 @@
+package sitemap
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker protects generations from routes whose enumeration keeps failing.
+//
+// When the enumeration of a route fails, the entries of its last successful enumeration are used instead,
+// so that the other routes still get fresh sitemaps. After Failures consecutive failures, the circuit of the route opens:
+// the route is not enumerated anymore for Cooldown, its last entries are used directly.
+// A route which never succeeded still fails the generation.
+//
+// The last entries of every route are kept in memory.
+type CircuitBreaker struct {
+	Failures int           // consecutive failures opening the circuit (1 if zero)
+	Cooldown time.Duration // time the circuit stays open
+}
+
+// circuit is the state of the circuit of a route.
+type circuit struct {
+	failures  int
+	openUntil time.Time
+	entries   []*Entry // entries of the last successful enumeration, nil if none
+}
+
+// circuits holds the circuits of the routes of a Router, by pattern.
+type circuits struct {
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+// get returns the circuit of the route with the given pattern.
+func (c *circuits) get(pattern string) *circuit {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.circuits == nil {
+		c.circuits = make(map[string]*circuit)
+	}
+	if c.circuits[pattern] == nil {
+		c.circuits[pattern] = new(circuit)
+	}
+	return c.circuits[pattern]
+}
+
+// open returns the sorted patterns of the routes whose circuit is open at now.
+func (c *circuits) open(now time.Time) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var patterns []string
+	for pattern, circuit := range c.circuits {
+		if now.Before(circuit.openUntil) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// protect returns a Source using the circuit of pattern to protect source.
+// Circuits are only used during generations, which never run concurrently.
+func (c *circuits) protect(pattern string, source Source, opts *Options) Source {
+	breaker := opts.CircuitBreaker
+	state := c.get(pattern)
+	return func(emit func(*Entry) error) error {
+		entries := state.entries
+		if !opts.now().Before(state.openUntil) {
+			var fresh []*Entry
+			err := source(func(e *Entry) error {
+				fresh = append(fresh, e)
+				return nil
+			})
+			if err == nil {
+				state.failures = 0
+				state.entries = fresh
+				entries = fresh
+			} else {
+				state.failures++
+				if state.failures >= breaker.Failures {
+					state.openUntil = opts.now().Add(breaker.Cooldown)
+				}
+				if state.entries == nil {
+					return err
+				}
+			}
+		}
+		for _, e := range entries {
+			err := emit(e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// OpenCircuits returns the patterns of the routes currently skipped by the circuit breaker (see Options.CircuitBreaker).
+func (r *Router) OpenCircuits() []string {
+	return r.circuits.open(r.options().now())
+}
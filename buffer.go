@@ -1,43 +1,180 @@
 package sitemap
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 )
 
 // Buffer is a sitemap buffer.
 //
-// When the current sitemap is full, it is offloaded to disk, and a new empty sitemap is created.
+// When the current sitemap is full, it is offloaded to storage, and a new empty sitemap is created.
 type Buffer struct {
 	sitemap   *Sitemap
-	count     int // number of sitemaps
+	count     int   // number of sitemaps
+	size      int64 // encoded size of the first measured entries of sitemap
+	measured  int
+	total     int // number of entries added
 	domain    string
-	cachePath string
-	Locations []string // Relative path of serialized sitemaps.
+	Storage   Storage         // Where sitemaps are written.
+	Locations []string        // Relative path of serialized sitemaps.
+	Files     []*ManifestFile // Description of all files written.
+
+	// FilePrefix is the beginning of the names of the sitemaps, "sitemap" if empty.
+	FilePrefix string
+	// Name, if set, is inserted into the names of the sitemaps (sitemap_<name>_<n>.xml),
+	// so that several buffers can write into the same storage.
+	Name string
+	// HashNames names the sitemaps after a hash of their content (sitemap_<hash>.xml)
+	// instead of their rank (sitemap_<n>.xml).
+	HashNames bool
+	// Schema is the schema of the sitemaps, SitemapSchema if nil.
+	Schema *Schema
+	// Text also writes each sitemap in the text format (one url per line), named sitemap_<n>.txt.
+	// Text sitemaps are never compressed nor referenced by the index.
+	Text bool
+	// Compression enables gzip compression of the sitemaps, if non-nil.
+	Compression *Compression
+	// Sink, if set, receives the sitemaps instead of Storage. Name, HashNames, Text and Compression
+	// are then ignored, and Locations and Files are left empty. The sinks of WriterSink, StorageSink and FileSink
+	// encode the sitemaps like Storage; the other sinks get the sitemaps to encode themselves.
+	Sink EntrySink
+	// XMLHeader replaces xml.Header at the beginning of the sitemaps, unless OmitXMLHeader is set.
+	XMLHeader     string
+	OmitXMLHeader bool
+	// PriorityPrecision, if set, is the number of decimals of the priorities (e.g. 2 for "0.80"), written exactly otherwise.
+	PriorityPrecision int
+	// OmitDefaultPriority leaves out the priorities equal to 0.5, the default of the sitemap protocol.
+	OmitDefaultPriority bool
+
+	release func(e *Entry) // called on each entry once its sitemap is written, if set
 }
 
 // NewBuffer creates a new buffer for sitemaps on the given domain. The path variable is the location for serialization on Flush().
 func NewBuffer(domain, path string) *Buffer {
+	return NewStorageBuffer(domain, DirStorage(path))
+}
+
+// NewStorageBuffer creates a new buffer for sitemaps on the given domain, serialized into s on Flush().
+func NewStorageBuffer(domain string, s Storage) *Buffer {
 	return &Buffer{
-		domain:    domain,
-		cachePath: path,
+		domain:  domain,
+		Storage: s,
 	}
 }
 
-const sitemap_pattern = "sitemap_%d.xml"
+const (
+	sitemap_pattern        = "%s_%s%d.xml"
+	hashed_sitemap_pattern = "%s_%s%s.xml"
+)
+
+// contentHash returns the hex-encoded hash used to name a sitemap with the given content.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeBuffers recycles the buffers used to encode sitemaps, which can reach tens of megabytes.
+var encodeBuffers = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// initialSitemapCapacity is the initial capacity of the entries of a sitemap in a buffer,
+// avoiding most reallocations while filling it.
+const initialSitemapCapacity = 4096
+
+// NewSinkBuffer creates a new buffer for sitemaps on the given domain, given to sink when full and on Flush().
+func NewSinkBuffer(domain string, sink EntrySink) *Buffer {
+	return &Buffer{
+		domain: domain,
+		Sink:   sink,
+	}
+}
 
 // Flush writes the content of the buffer to a sitemap file and adds the file to the list of locations.
 // This occurs only if the buffer is non-empty. Calling Flush on an empty buffer is a no-op.
 func (b *Buffer) Flush() error {
-	if !b.sitemap.IsEmpty() {
+	if b.Sink != nil && !b.sitemap.IsEmpty() {
+		b.count++
+		var err error
+		if sink, ok := b.Sink.(encodingSink); ok {
+			err = sink.encodeSitemap(b.count, b.sitemap, b.encoder)
+		} else {
+			err = b.Sink.WriteSitemap(b.count, b.sitemap)
+		}
+		if err != nil {
+			return err
+		}
+	} else if !b.sitemap.IsEmpty() {
 		b.count++
-		location := fmt.Sprintf(sitemap_pattern, b.count)
-		err := b.sitemap.WriteToFile(b.cachePath + location)
+		data := encodeBuffers.Get().(*bytes.Buffer)
+		data.Reset()
+		defer encodeBuffers.Put(data)
+		err := b.encoder(data).encode(b.sitemap)
 		if err != nil {
 			return err
 		}
+		prefix := ""
+		if b.Name != "" {
+			prefix = b.Name + "_"
+		}
+		filePrefix := b.FilePrefix
+		if filePrefix == "" {
+			filePrefix = default_sitemap_prefix
+		}
+		location := fmt.Sprintf(sitemap_pattern, filePrefix, prefix, b.count)
+		if b.HashNames {
+			location = fmt.Sprintf(hashed_sitemap_pattern, filePrefix, prefix, contentHash(data.Bytes()))
+		}
+		urls := len(b.sitemap.Entries)
+		if b.Compression == nil || b.Compression.KeepUncompressed {
+			err = b.writeFile(location, data.Bytes(), urls)
+			if err != nil {
+				return err
+			}
+		}
+		if b.Text {
+			err = b.writeText(strings.TrimSuffix(location, ".xml")+text_extension, urls)
+			if err != nil {
+				return err
+			}
+		}
+		if b.Compression != nil {
+			compressed, err := b.Compression.compress(data.Bytes())
+			if err != nil {
+				return err
+			}
+			location += gzip_extension
+			err = b.writeFile(location, compressed, urls)
+			if err != nil {
+				return err
+			}
+		}
 		b.Locations = append(b.Locations, location)
 	}
+	if b.release != nil && b.sitemap != nil {
+		for _, e := range b.sitemap.Entries {
+			b.release(e)
+		}
+	}
 	b.sitemap = nil
+	b.size, b.measured = 0, 0
+	return nil
+}
+
+// writeFile writes data to the file location of the storage, and records it in b.Files.
+func (b *Buffer) writeFile(location string, data []byte, urls int) error {
+	err := b.Storage.WriteFile(location, data)
+	if err != nil {
+		return err
+	}
+	b.Files = append(b.Files, newManifestFile(location, data, urls))
 	return nil
 }
 
@@ -52,8 +189,73 @@ func (b *Buffer) AddEntry(e *Entry) error {
 	}
 	if b.sitemap == nil {
 		b.sitemap = NewSitemap()
+		if b.Schema != nil {
+			b.sitemap.Schema = b.Schema
+		}
+		b.sitemap.Entries = make([]*Entry, 0, initialSitemapCapacity)
+	}
+	if len(e.Alternates) > 0 && b.sitemap.Schema.XmlnsXhtml == "" {
+		b.sitemap.Schema = withXHTML(b.sitemap.Schema)
 	}
 
 	b.sitemap.Entries = append(b.sitemap.Entries, e)
+	b.total++
 	return nil
 }
+
+// Count returns the number of entries of the current sitemap, which are not flushed yet.
+func (b *Buffer) Count() int {
+	if b.sitemap == nil {
+		return 0
+	}
+	return len(b.sitemap.Entries)
+}
+
+// CurrentSize returns the size in bytes of the current sitemap once encoded (before compression), 0 if it is empty.
+// Only the entries added since the previous call are measured.
+func (b *Buffer) CurrentSize() int64 {
+	if b.sitemap.IsEmpty() {
+		return 0
+	}
+	var n byteCounter
+	e := b.encoder(&n)
+	for _, entry := range b.sitemap.Entries[b.measured:] {
+		e.entry(entry)
+	}
+	b.size += int64(n)
+	b.measured = len(b.sitemap.Entries)
+	n = 0
+	e.open(b.sitemap.Schema)
+	e.close(len(b.sitemap.Entries))
+	return int64(n) + b.size
+}
+
+// encoder returns an encoder of the sitemaps of b into w, with the XML declaration and the priority format of b.
+func (b *Buffer) encoder(w io.Writer) *sitemapEncoder {
+	return &sitemapEncoder{
+		w:                   w,
+		header:              xmlHeader(b.XMLHeader, b.OmitXMLHeader),
+		scratch:             make([]byte, 0, 256),
+		precision:           b.PriorityPrecision,
+		omitDefaultPriority: b.OmitDefaultPriority,
+	}
+}
+
+// TotalEntries returns the number of entries added to the buffer, flushed or not.
+func (b *Buffer) TotalEntries() int {
+	return b.total
+}
+
+const text_extension = ".txt"
+
+// writeText writes the locations of the current sitemap into the file location, one per line.
+func (b *Buffer) writeText(location string, urls int) error {
+	data := encodeBuffers.Get().(*bytes.Buffer)
+	data.Reset()
+	defer encodeBuffers.Put(data)
+	for _, e := range b.sitemap.Entries {
+		data.WriteString(e.Location)
+		data.WriteByte('\n')
+	}
+	return b.writeFile(location, data.Bytes(), urls)
+}
@@ -0,0 +1,144 @@
+package sitemap
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferAccessors(t *testing.T) {
+	b := NewStorageBuffer("http://example.com", NewMemoryStorage())
+	if b.Count() != 0 || b.CurrentSize() != 0 || b.TotalEntries() != 0 {
+		t.Errorf("Empty buffer should have no entries, got %d, %d bytes, %d in total", b.Count(), b.CurrentSize(), b.TotalEntries())
+	}
+	sm := testSitemap(3)
+	sm.Entries[1].Alternates = []*Alternate{HreflangAlternate("fr", "http://example.com/fr/documents/1")}
+	for i, e := range sm.Entries {
+		err := b.AddEntry(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 && b.CurrentSize() == 0 {
+			t.Error("Current size should not be 0")
+		}
+	}
+	sm.Schema = b.sitemap.Schema
+	encoded := new(bytes.Buffer)
+	err := encodeSitemap(encoded, sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Count() != 3 || b.CurrentSize() != int64(encoded.Len()) || b.TotalEntries() != 3 {
+		t.Errorf("Expecting 3 entries, %d bytes, 3 in total, got %d, %d bytes, %d in total", encoded.Len(), b.Count(), b.CurrentSize(), b.TotalEntries())
+	}
+	err = b.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Count() != 0 || b.CurrentSize() != 0 || b.TotalEntries() != 3 {
+		t.Errorf("Expecting no entries, 0 bytes, 3 in total, got %d, %d bytes, %d in total", b.Count(), b.CurrentSize(), b.TotalEntries())
+	}
+}
+
+func TestSinkBuffer(t *testing.T) {
+	var sizes []int
+	b := NewSinkBuffer("http://example.com", EntrySinkFunc(func(n int, s *Sitemap) error {
+		if n != len(sizes)+1 {
+			t.Errorf("Expecting sitemap %d, got %d", len(sizes)+1, n)
+		}
+		sizes = append(sizes, len(s.Entries))
+		return nil
+	}))
+	sm := testSitemap(max_sitemap_urls + 1)
+	for _, e := range sm.Entries {
+		err := b.AddEntry(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := b.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] != max_sitemap_urls || sizes[1] != 1 {
+		t.Errorf("Expecting sitemaps of %d and 1 entries, got %v", max_sitemap_urls, sizes)
+	}
+	if len(b.Locations) != 0 || len(b.Files) != 0 {
+		t.Errorf("No file should be recorded, got %v", b.Locations)
+	}
+}
+
+func TestStorageSink(t *testing.T) {
+	storage := NewMemoryStorage()
+	b := NewSinkBuffer("http://example.com", StorageSink(storage, "out/sitemap_%d.xml"))
+	for _, e := range testSitemap(2).Entries {
+		err := b.AddEntry(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := b.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := new(bytes.Buffer)
+	err = encodeSitemap(expected, testSitemap(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readFile(storage, "out/sitemap_1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected.Bytes()) {
+		t.Errorf("Expecting:\n%s\nbut got:\n%s", expected, data)
+	}
+}
+
+func TestSinkEncoding(t *testing.T) {
+	sm := testSitemap(3)
+	half := 0.5
+	sm.Entries[1].Priority = &half
+	format := func(b *Buffer) *Buffer {
+		b.XMLHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+		b.PriorityPrecision = 2
+		b.OmitDefaultPriority = true
+		return b
+	}
+
+	storage, sinkStorage, written := NewMemoryStorage(), NewMemoryStorage(), new(bytes.Buffer)
+	for _, b := range []*Buffer{
+		format(NewStorageBuffer("http://example.com", storage)),
+		format(NewSinkBuffer("http://example.com", StorageSink(sinkStorage, "sitemap_%d.xml"))),
+		format(NewSinkBuffer("http://example.com", WriterSink(written))),
+	} {
+		for _, e := range sm.Entries {
+			err := b.AddEntry(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := b.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := readFile(storage, "sitemap_1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(expected, []byte("standalone")) || !bytes.Contains(expected, []byte("<priority>0.80</priority>")) ||
+		bytes.Contains(expected, []byte("0.50")) {
+		t.Fatalf("The options of the buffer should apply:\n%s", expected)
+	}
+	data, err := readFile(sinkStorage, "sitemap_1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Expecting StorageSink to write:\n%s\nbut got:\n%s", expected, data)
+	}
+	if !bytes.Equal(written.Bytes(), expected) {
+		t.Errorf("Expecting WriterSink to write:\n%s\nbut got:\n%s", expected, written)
+	}
+}
@@ -0,0 +1,45 @@
+package sitemap
+
+// CanonicalResolver returns the canonical url of the full url loc, or loc itself if it is canonical.
+// See Options.Canonical.
+type CanonicalResolver func(loc string) string
+
+// canonicalizer applies Options.Canonical to the entries of a generation.
+type canonicalizer struct {
+	resolve CanonicalResolver
+	replace bool
+	seen    map[string]bool // locations emitted so far, when replacing
+}
+
+func newCanonicalizer(opts *Options) *canonicalizer {
+	c := &canonicalizer{
+		resolve: opts.Canonical,
+		replace: opts.ReplaceNonCanonical,
+	}
+	if c.replace {
+		c.seen = make(map[string]bool)
+	}
+	return c
+}
+
+// accept returns false if e must be left out: e is not canonical, or its canonical has already been emitted.
+// When replacing, the location of e is set to its canonical.
+func (c *canonicalizer) accept(e *Entry) bool {
+	if c.resolve == nil {
+		return true
+	}
+	canonical := EscapeLoc(c.resolve(e.Location))
+	if canonical != e.Location {
+		if !c.replace {
+			return false
+		}
+		e.Location = canonical
+	}
+	if c.replace {
+		if c.seen[e.Location] {
+			return false
+		}
+		c.seen[e.Location] = true
+	}
+	return true
+}
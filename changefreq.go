@@ -0,0 +1,85 @@
+package sitemap
+
+import (
+	"sync"
+	"time"
+)
+
+// ChangeTracker derives the change frequency of entries from the changes of their lastmod across generations,
+// see Options.ChangeTracker. The same tracker must be used by successive generations; its zero value is ready to use.
+//
+// Entries with a change frequency set by their source, and entries without lastmod, are left alone.
+// An entry gets a change frequency once its lastmod has changed at least once: the average time between
+// its changes is rounded up to hourly, daily, weekly, monthly or yearly.
+// The tracker keeps one record per url in memory.
+type ChangeTracker struct {
+	mutex      sync.Mutex
+	generation int
+	urls       map[string]*urlChanges
+}
+
+// urlChanges records the changes of the lastmod of a url.
+type urlChanges struct {
+	first, last time.Time // first and last lastmod seen
+	changes     int       // number of times the lastmod changed
+	generation  int       // last generation the url was seen in
+}
+
+// begin starts a generation.
+func (c *ChangeTracker) begin() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+	if c.urls == nil {
+		c.urls = make(map[string]*urlChanges)
+	}
+}
+
+// end forgets the urls which were not part of the generation, which must have succeeded.
+func (c *ChangeTracker) end() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for loc, u := range c.urls {
+		if u.generation != c.generation {
+			delete(c.urls, loc)
+		}
+	}
+}
+
+// apply records the lastmod of e, and sets its change frequency if unset and known.
+func (c *ChangeTracker) apply(e *Entry) {
+	if e.LastModification == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	lastmod := *e.LastModification
+	u := c.urls[e.Location]
+	if u == nil {
+		u = &urlChanges{first: lastmod, last: lastmod}
+		c.urls[e.Location] = u
+	}
+	u.generation = c.generation
+	if !lastmod.Equal(u.last) {
+		u.changes++
+		u.last = lastmod
+	}
+	if u.changes > 0 && e.ChangeFrequency == "" {
+		e.ChangeFrequency = frequencyOf(u.last.Sub(u.first) / time.Duration(u.changes))
+	}
+}
+
+// frequencyOf returns the change frequency of a page changing every interval on average.
+func frequencyOf(interval time.Duration) ChangeFrequency {
+	switch {
+	case interval <= time.Hour:
+		return Hourly
+	case interval <= 24*time.Hour:
+		return Daily
+	case interval <= 7*24*time.Hour:
+		return Weekly
+	case interval <= 31*24*time.Hour:
+		return Monthly
+	}
+	return Yearly
+}
@@ -0,0 +1,58 @@
+package sitemap
+
+import (
+	"time"
+)
+
+// Clock provides the current time.
+//
+// The router asks its clock whenever it needs "now" (e.g. the lastmod of the sitemap index),
+// so tests can control time and generations can be made reproducible.
+type Clock interface {
+	Now() time.Time
+}
+
+// Timer is a Clock able to call a function once a duration has elapsed on it, e.g. for the delayed notifications
+// of ThrottleNotifier, the delays of Options.Retry or the polls of Manager.Run().
+// SystemClock is a Timer; the Clocks which are not rely on time.AfterFunc().
+type Timer interface {
+	Clock
+	AfterFunc(d time.Duration, f func())
+}
+
+// SystemClock is the default Clock, based on time.Now().
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+// afterFunc calls f once d has elapsed on c.
+func afterFunc(c Clock, d time.Duration, f func()) {
+	if t, ok := c.(Timer); ok {
+		t.AfterFunc(d, f)
+		return
+	}
+	time.AfterFunc(d, f)
+}
+
+// sleep pauses the current goroutine until d has elapsed on c.
+func sleep(c Clock, d time.Duration) {
+	done := make(chan struct{})
+	afterFunc(c, d, func() { close(done) })
+	<-done
+}
+
+// FixedClock is a Clock which always returns the same time.
+type FixedClock time.Time
+
+// Now returns the fixed time.
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
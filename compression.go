@@ -0,0 +1,42 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// Compression configures the gzip compression of sitemaps.
+// Compressed sitemaps are named sitemap_<n>.xml.gz, and referenced as such in the index.
+// The index itself is never compressed.
+type Compression struct {
+	// Level is the gzip level, from gzip.BestSpeed to gzip.BestCompression.
+	// Use gzip.DefaultCompression if unsure; the zero value is gzip.NoCompression.
+	Level int
+	// KeepUncompressed also writes the uncompressed sitemap_<n>.xml files.
+	KeepUncompressed bool
+}
+
+// DefaultCompression compresses sitemaps with gzip's default level.
+var DefaultCompression = &Compression{
+	Level: gzip.DefaultCompression,
+}
+
+const gzip_extension = ".gz"
+
+// compress returns data compressed with c.Level.
+func (c *Compression) compress(data []byte) ([]byte, error) {
+	out := new(bytes.Buffer)
+	w, err := gzip.NewWriterLevel(out, c.Level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,57 @@
+package sitemap
+
+import (
+	"compress/gzip"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Compression = &Compression{Level: gzip.BestCompression, KeepUncompressed: true}
+	source := EntrySource(&Entry{FileReference: &FileReference{Location: "http://example.com/"}})
+
+	_, err = GenerateToDir([]Source{source}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := new(SitemapIndex)
+	mustReadXML(filepath.Join(dir, "sitemapindex.xml"), index, t)
+	if len(index.SitemapRefs) != 1 || index.SitemapRefs[0].Location != "http://example.com/sitemap_1.xml.gz" {
+		t.Fatalf("Unexpected sitemap index %v", index.SitemapRefs)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "sitemap_1.xml.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := new(Sitemap)
+	err = xml.NewDecoder(gz).Decode(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sm.Entries) != 1 {
+		t.Errorf("Expecting 1 but got %d urls in sitemap", len(sm.Entries))
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "sitemap_1.xml"))
+	if err != nil {
+		t.Errorf("Uncompressed sitemap should be kept: %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package sitemap
+
+import (
+	"github.com/gorilla/mux"
+)
+
+// RegisterSecret creates a route which is deliberately left out of the sitemap.
+// It behaves like r.Path(pattern), but the route is reported as Secret by Coverage() rather than Unlisted.
+func (r *Router) RegisterSecret(pattern string) *mux.Route {
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+	r.secretRoutes = append(r.secretRoutes, pattern)
+	return r.Path(pattern)
+}
+
+// RouteStatus tells whether the pages of a route appear in the sitemap.
+type RouteStatus string
+
+const (
+	Listed   RouteStatus = "listed"   // registered with Register() or RegisterParam()
+	Secret   RouteStatus = "secret"   // registered with RegisterSecret(), or serving the sitemap files
+	Unlisted RouteStatus = "unlisted" // handled without registration, e.g. with r.HandleFunc(), maybe forgotten
+)
+
+// RouteCoverage is the status of a route of the router.
+type RouteCoverage struct {
+	Pattern string
+	Status  RouteStatus
+}
+
+// Coverage returns the status of every route of the embedded mux.Router with a path template, in registration order,
+// so that routes forgotten in the sitemap (Unlisted) can be told apart from deliberately hidden ones (Secret).
+func (r *Router) Coverage() ([]RouteCoverage, error) {
+	static, params := r.registered()
+	status := make(map[string]RouteStatus, len(static)+len(params))
+	r.routesMutex.RLock()
+	for _, pattern := range r.secretRoutes {
+		status[pattern] = Secret
+	}
+	r.routesMutex.RUnlock()
+	for _, route := range routeInfos(static, params) {
+		status[route.Pattern] = Listed
+	}
+
+	var coverage []RouteCoverage
+	err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pattern, err := route.GetPathTemplate()
+		if err != nil {
+			return nil // no path (e.g. a host matcher only)
+		}
+		s, ok := status[pattern]
+		if !ok {
+			s = Unlisted
+		}
+		coverage = append(coverage, RouteCoverage{Pattern: pattern, Status: s})
+		return nil
+	})
+	return coverage, err
+}
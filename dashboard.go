@@ -0,0 +1,91 @@
+package sitemap
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// dashboardData is rendered by dashboardTemplate.
+type dashboardData struct {
+	Stats    Stats
+	Manifest *Manifest
+	Fetches  []Fetches
+	MaxURLs  int // largest url count in the history, to scale the bars
+}
+
+// Percent returns the share of urls in the largest url count, in percents.
+func (d *dashboardData) Percent(urls int) int {
+	if d.MaxURLs == 0 {
+		return 0
+	}
+	return urls * 100 / d.MaxURLs
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sitemaps</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+.bar { background: #4a90d9; height: 0.8em; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Sitemaps</h1>
+<p>{{.Stats.Generations}} generations, {{.Stats.Failures}} failed.
+{{with .Stats.LastError}}<span class="error">Last generation failed: {{.}}</span>{{end}}</p>
+
+<h2>Current generation</h2>
+{{with .Manifest}}
+<p>Generated {{.Generated}}, {{.URLs}} urls{{if .DroppedURLs}} ({{.DroppedURLs}} dropped){{end}}.</p>
+<table>
+<tr><th>File</th><th>URLs</th><th>Bytes</th><th>SHA-256</th></tr>
+{{range .Files}}<tr><td>{{.Name}}</td><td>{{.URLs}}</td><td>{{.Size}}</td><td><code>{{.SHA256}}</code></td></tr>
+{{end}}</table>
+{{else}}<p>No sitemaps generated yet.</p>{{end}}
+
+<h2>History</h2>
+<table>
+<tr><th>Start</th><th>Duration</th><th>URLs</th><th></th><th>Files</th><th>Bytes</th><th>Error</th></tr>
+{{range .Stats.History}}<tr><td>{{.Start}}</td><td>{{.Duration}}</td><td>{{.URLs}}</td>
+<td style="width: 200px"><div class="bar" style="width: {{$.Percent .URLs}}%"></div></td>
+<td>{{.Files}}</td><td>{{.Bytes}}</td><td class="error">{{with .Error}}{{.}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Fetches</h2>
+<table>
+<tr><th>File</th><th>Count</th><th>Last</th><th>Last user agent</th></tr>
+{{range .Fetches}}<tr><td>{{.File}}</td><td>{{.Count}}</td><td>{{.Last}}</td><td>{{.LastUserAgent}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// DashboardHandler returns an http.Handler showing an HTML page with the current generation (files, sizes, url counts),
+// the history of generations, and the requests served on each sitemap file (e.g. by crawlers).
+//
+// The handler is not registered anywhere: mount it on a private route.
+func (r *Router) DashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data := &dashboardData{
+			Stats:   r.Stats(),
+			Fetches: r.Fetches(),
+		}
+		data.Manifest, _ = r.Manifest() // nil if not generated yet
+		for _, g := range data.Stats.History {
+			if g.URLs > data.MaxURLs {
+				data.MaxURLs = g.URLs
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := dashboardTemplate.Execute(w, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RouteReport is the result of the enumeration of a route by Inspect().
+type RouteReport struct {
+	Pattern  string
+	Count    int           // number of entries produced
+	Duration time.Duration // time taken by the enumeration
+	Entries  []*Entry      // first entries produced
+	Error    string        // error of the enumeration, if any
+}
+
+// Inspect enumerates every registered route as a generation would, but without writing anything,
+// and reports the number of entries, the duration and the first n entries of each route.
+//
+// Inspect does not take the sitemap lock, so it never delays the serving of sitemaps.
+func (r *Router) Inspect(n int) []*RouteReport {
+	opts := r.options()
+	sources, routes := r.sources(opts, false)
+	reports := make([]*RouteReport, len(sources))
+	for i, source := range sources {
+		report := &RouteReport{Pattern: routes[i].Pattern}
+		start := opts.now()
+		err := source(func(e *Entry) error {
+			report.Count++
+			if len(report.Entries) < n {
+				report.Entries = append(report.Entries, e)
+			}
+			return nil
+		})
+		report.Duration = opts.now().Sub(start)
+		if err != nil {
+			report.Error = err.Error()
+		}
+		reports[i] = report
+	}
+	return reports
+}
+
+// DefaultInspectCount is the number of entries per route shown by the debug handler by default.
+const DefaultInspectCount = 10
+
+// DebugHandler returns an http.Handler replying the result of r.Inspect() in JSON.
+// The number of entries shown per route can be set with the query parameter n (DefaultInspectCount by default).
+//
+// The handler is not registered anywhere: mount it on a private route, as enumerating
+// every route on each request may be expensive.
+func (r *Router) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := DefaultInspectCount
+		if param := req.URL.Query().Get("n"); param != "" {
+			var err error
+			n, err = strconv.Atoi(param)
+			if err == nil && n < 0 {
+				err = errors.New("negative count")
+			}
+			if err != nil {
+				http.Error(w, "invalid parameter n: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		data, err := json.MarshalIndent(r.Inspect(n), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(data)
+	})
+}
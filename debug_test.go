@@ -0,0 +1,179 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDebugHandler(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Register("/about")
+	r.RegisterParam("/documents/{id}", func(cb func(...string) error) error {
+		for _, id := range []string{"a", "b", "c"} {
+			err := cb("id", id)
+			if err != nil {
+				return err
+			}
+		}
+		return errors.New("database is down")
+	})
+
+	req, err := http.NewRequest("GET", "http://example.com/debug?n=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r.DebugHandler().ServeHTTP(w, req)
+
+	var reports []*RouteReport
+	err = json.Unmarshal(w.Body.Bytes(), &reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("Expecting 2 reports but got %d", len(reports))
+	}
+	report := reports[1]
+	if report.Pattern != "/documents/{id}" || report.Count != 3 || len(report.Entries) != 2 || report.Error != "database is down" {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if report.Entries[0].Location != "http://example.com/documents/a" || report.Entries[1].Location != "http://example.com/documents/b" {
+		t.Errorf("Expecting distinct entries, got %s and %s", report.Entries[0].Location, report.Entries[1].Location)
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about")
+	handler := r.HandleSitemaps()
+	mustServeXML(handler, "http://example.com/sitemapindex.xml", new(SitemapIndex), t)
+
+	req, err := http.NewRequest("GET", "http://example.com/dashboard", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r.DashboardHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Dashboard replies %d: %s", w.Code, w.Body.String())
+	}
+	for _, s := range []string{"sitemap_1.xml", "sitemapindex.xml", "1 generations"} {
+		if !strings.Contains(w.Body.String(), s) {
+			t.Errorf("Dashboard should contain %q", s)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/sitemap_"+strconv.Itoa(100+i)+".xml", nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("Expecting 404 for a missing sitemap, got %d", w.Code)
+		}
+	}
+	if fetches := r.Fetches(); len(fetches) != 1 || fetches[0].File != "sitemapindex.xml" || fetches[0].Count != 1 {
+		t.Errorf("Only the files served should be recorded, got %v", fetches)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	_, err := r.Forecast()
+	if err == nil {
+		t.Error("Forecast should fail without history")
+	}
+
+	start := time.Date(2014, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for day, urls := range []int{40000, 41000, 42000} {
+		m := &Manifest{URLs: urls}
+		r.stats.record(m, start.Add(time.Duration(day)*24*time.Hour), time.Second, nil)
+	}
+	r.stats.record(nil, start.Add(72*time.Hour), time.Second, errors.New("failed"))
+
+	f, err := r.Forecast()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.URLs != 42000 || f.Sitemaps != 1 || f.GrowthPerDay < 999.9 || f.GrowthPerDay > 1000.1 {
+		t.Errorf("Unexpected forecast %+v", f)
+	}
+	// 8001 more urls at 1000 per day
+	if expected := start.Add(48*time.Hour + 8001*24*time.Hour/1000); f.NextSitemap.Sub(expected) > time.Second || expected.Sub(f.NextSitemap) > time.Second {
+		t.Errorf("Expecting next sitemap at %v but got %v", expected, f.NextSitemap)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/")
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		for _, id := range []string{"a", "b", "c"} {
+			err := cb("id", id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	r.AddFilter(func(e *Entry) bool {
+		return e.Location != "http://example.com/doc/b"
+	})
+
+	plan, err := r.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RoutePlan{{Pattern: "/", Entries: 1}, {Pattern: "/doc/{id}", Entries: 3}}
+	if !reflect.DeepEqual(plan.Routes, expected) {
+		t.Errorf("Expecting %v but got %v", expected, plan.Routes)
+	}
+	if plan.Manifest.URLs != 3 || len(plan.Manifest.Files) != 2 {
+		t.Errorf("Expecting 3 urls in a sitemap and the index, got %d urls in %d files", plan.Manifest.URLs, len(plan.Manifest.Files))
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Plan should not write anything, got %d files", len(files))
+	}
+
+	r.Options.Delta = new(Delta)
+	if _, err = r.Plan(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = r.GenerateSitemaps(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sitemap_delta_1.xml")); err != nil {
+		t.Errorf("Plan should not record a generation in the delta: %v", err)
+	}
+
+	r.Options.ServerPath = "/{invalid}/"
+	_, err = r.Plan()
+	if err == nil {
+		t.Error("Plan should report invalid options")
+	}
+}
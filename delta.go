@@ -0,0 +1,51 @@
+package sitemap
+
+import (
+	"sync"
+	"time"
+)
+
+// Delta writes the entries added or modified since the previous generation into sitemap_delta_<n>.xml,
+// see Options.Delta. The delta sitemaps are listed in the manifest but not referenced by the index: the full set
+// of sitemaps is still written, cooperating crawlers and internal systems fetch the delta to sync cheaply.
+// Files of previous generations are not removed: only the delta sitemaps listed in the manifest are current
+// (none if nothing changed).
+//
+// The same Delta must be used by successive generations; its zero value is ready to use. An entry is modified
+// if its lastmod changed. The first generation has no previous one: all its entries are in the delta.
+// Delta keeps one record per url in memory.
+type Delta struct {
+	mutex    sync.Mutex
+	previous map[string]time.Time // lastmod of each url of the previous generation (zero if none)
+	current  map[string]time.Time
+}
+
+// delta_sitemap_name is the name of the buffer of Options.Delta.
+const delta_sitemap_name = "delta"
+
+// begin starts a generation.
+func (d *Delta) begin() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.current = make(map[string]time.Time, len(d.previous))
+}
+
+// changed records e, and returns true if it was added or modified since the previous generation.
+func (d *Delta) changed(e *Entry) bool {
+	var lastmod time.Time
+	if e.LastModification != nil {
+		lastmod = *e.LastModification
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.current[e.Location] = lastmod
+	previous, ok := d.previous[e.Location]
+	return !ok || !previous.Equal(lastmod)
+}
+
+// end makes the generation, which must have succeeded, the previous one.
+func (d *Delta) end() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.previous, d.current = d.current, nil
+}
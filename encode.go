@@ -0,0 +1,265 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// sitemapEncoder encodes sitemaps without reflection, producing the same output as encodeXML.
+// It reuses its scratch buffer, so that encoding an entry doesn't allocate.
+//
+// It only knows the fields of Entry: XML extensions must be encoded with encodeXML.
+type sitemapEncoder struct {
+	w       io.Writer
+	header  string // XML declaration written before the root element
+	scratch []byte
+	err     error
+
+	precision           int  // number of decimals of the priorities, shortest exact form if 0
+	omitDefaultPriority bool // leave out the priorities equal to default_priority
+}
+
+// StandaloneXMLHeader is an XML declaration for Options.XMLHeader, declaring the files standalone.
+const StandaloneXMLHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+// xmlHeader returns the XML declaration of files with the given custom header (xml.Header if empty),
+// or an empty declaration if omit is set.
+func xmlHeader(header string, omit bool) string {
+	if omit {
+		return ""
+	}
+	if header == "" {
+		return xml.Header
+	}
+	return header
+}
+
+func (e *sitemapEncoder) write(b []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(b)
+	}
+}
+
+func (e *sitemapEncoder) writeString(s string) {
+	e.scratch = append(e.scratch[:0], s...)
+	e.write(e.scratch)
+}
+
+// escape writes s escaped as encoding/xml does.
+func (e *sitemapEncoder) escape(s string) {
+	e.scratch = e.scratch[:0]
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			e.scratch = append(e.scratch, "&#34;"...)
+		case '\'':
+			e.scratch = append(e.scratch, "&#39;"...)
+		case '&':
+			e.scratch = append(e.scratch, "&amp;"...)
+		case '<':
+			e.scratch = append(e.scratch, "&lt;"...)
+		case '>':
+			e.scratch = append(e.scratch, "&gt;"...)
+		case '\t':
+			e.scratch = append(e.scratch, "&#x9;"...)
+		case '\n':
+			e.scratch = append(e.scratch, "&#xA;"...)
+		case '\r':
+			e.scratch = append(e.scratch, "&#xD;"...)
+		default:
+			e.scratch = append(e.scratch, c)
+		}
+	}
+	e.write(e.scratch)
+}
+
+func (e *sitemapEncoder) attribute(name, value string) {
+	e.writeString(" " + name + `="`)
+	e.escape(value)
+	e.writeString(`"`)
+}
+
+func (e *sitemapEncoder) element(indent, name, value string) {
+	e.writeString(indent + "<" + name + ">")
+	e.escape(value)
+	e.writeString("</" + name + ">")
+}
+
+func (e *sitemapEncoder) time(t *time.Time, precision DatePrecision) {
+	e.writeString("\n    <lastmod>")
+	e.scratch = t.AppendFormat(e.scratch[:0], precision.layout())
+	e.write(e.scratch)
+	e.writeString("</lastmod>")
+}
+
+func (e *sitemapEncoder) priority(p float64) {
+	e.writeString("\n    <priority>")
+	var err error
+	e.scratch, err = appendPriorityPrecision(e.scratch[:0], p, e.precision)
+	if err != nil && e.err == nil {
+		e.err = err
+	}
+	e.write(e.scratch)
+	e.writeString("</priority>")
+}
+
+// default_priority is the priority assumed by crawlers for the entries without one.
+const default_priority = 0.5
+
+// appendPriority appends p to b in decimal notation, with the fewest digits representing it exactly (e.g. 0.75),
+// or fails if p is not between 0 and 1.
+func appendPriority(b []byte, p float64) ([]byte, error) {
+	return appendPriorityPrecision(b, p, 0)
+}
+
+// appendPriorityPrecision appends p to b like appendPriority, rounded to precision decimals if positive.
+// The output never depends on the locale nor uses an exponent, as required by the sitemap protocol.
+func appendPriorityPrecision(b []byte, p float64, precision int) ([]byte, error) {
+	if !(p >= 0 && p <= 1) {
+		return b, fmt.Errorf("sitemap: invalid priority %v", p)
+	}
+	if precision <= 0 {
+		precision = -1
+	}
+	return strconv.AppendFloat(b, p, 'f', precision, 64), nil
+}
+
+// schema writes the attributes of s.
+func (e *sitemapEncoder) schema(s *Schema) {
+	if s != nil {
+		e.attribute("xmlns", s.Xmlns)
+		if s.XmlnsXsi != "" {
+			e.attribute("xmlns:xsi", s.XmlnsXsi)
+		}
+		if s.XsiSchemaLocation != "" {
+			e.attribute("xsi:schemaLocation", s.XsiSchemaLocation)
+		}
+		if s.XmlnsXhtml != "" {
+			e.attribute("xmlns:xhtml", s.XmlnsXhtml)
+		}
+		for _, attr := range s.Extra {
+			e.attribute(attr.Name.Local, attr.Value)
+		}
+	}
+}
+
+// fileReference writes the elements of ref.
+func (e *sitemapEncoder) fileReference(ref *FileReference) {
+	e.element("\n    ", "loc", ref.Location)
+	if ref.LastModification != nil {
+		e.time(ref.LastModification, ref.LastModPrecision)
+	}
+}
+
+// encode writes the XML header and s, indented as encodeXML does.
+func (e *sitemapEncoder) encode(s *Sitemap) error {
+	e.open(s.Schema)
+	for _, entry := range s.Entries {
+		e.entry(entry)
+	}
+	e.close(len(s.Entries))
+	return e.err
+}
+
+// open writes the XML header and the opening urlset tag.
+func (e *sitemapEncoder) open(schema *Schema) {
+	e.writeString(e.header)
+	e.writeString("<urlset")
+	e.schema(schema)
+	e.writeString(">")
+}
+
+// entry writes the url element of entry.
+func (e *sitemapEncoder) entry(entry *Entry) {
+	e.writeString("\n  <url>")
+	if entry.FileReference != nil {
+		e.fileReference(entry.FileReference)
+	}
+	if entry.ChangeFrequency != "" {
+		if !entry.ChangeFrequency.Valid() && e.err == nil {
+			e.err = invalidChangeFrequency(entry.ChangeFrequency)
+		}
+		e.element("\n    ", "changefreq", string(entry.ChangeFrequency))
+	}
+	if entry.Priority != nil && !(e.omitDefaultPriority && *entry.Priority == default_priority) {
+		e.priority(*entry.Priority)
+	}
+	for _, a := range entry.Alternates {
+		e.writeString("\n    <xhtml:link")
+		e.attribute("rel", a.Rel)
+		if a.Hreflang != "" {
+			e.attribute("hreflang", a.Hreflang)
+		}
+		e.attribute("href", a.Href)
+		e.writeString("></xhtml:link>")
+	}
+	e.writeString("\n  </url>")
+}
+
+// close writes the closing urlset tag of a sitemap with the given number of entries.
+func (e *sitemapEncoder) close(entries int) {
+	if entries > 0 {
+		e.writeString("\n")
+	}
+	e.writeString("</urlset>")
+}
+
+// byteCounter is a writer counting the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// encodeIndex writes the XML header and s, indented as encodeXML does.
+func (e *sitemapEncoder) encodeIndex(s *SitemapIndex) error {
+	e.writeString(e.header)
+	e.writeString("<sitemapindex")
+	e.schema(s.Schema)
+	e.writeString(">")
+	for _, ref := range s.SitemapRefs {
+		e.writeString("\n  <sitemap>")
+		e.fileReference(ref)
+		e.writeString("\n  </sitemap>")
+	}
+	if len(s.SitemapRefs) > 0 {
+		e.writeString("\n")
+	}
+	e.writeString("</sitemapindex>")
+	return e.err
+}
+
+// encodeSitemap writes s to w in XML, with the header.
+// It is equivalent to encodeXML(w, s), but much faster for large sitemaps.
+func encodeSitemap(w io.Writer, s *Sitemap) error {
+	return encodeSitemapHeader(w, s, xml.Header)
+}
+
+// encodeSitemapHeader writes s to w in XML like encodeSitemap, with the given XML declaration instead of xml.Header.
+func encodeSitemapHeader(w io.Writer, s *Sitemap, header string) error {
+	e := &sitemapEncoder{
+		w:       w,
+		header:  header,
+		scratch: make([]byte, 0, 256),
+	}
+	return e.encode(s)
+}
+
+// encodeSitemapIndex writes s to w in XML, with the header, like encodeSitemap.
+func encodeSitemapIndex(w io.Writer, s *SitemapIndex) error {
+	return encodeSitemapIndexHeader(w, s, xml.Header)
+}
+
+// encodeSitemapIndexHeader writes s to w in XML like encodeSitemapIndex, with the given XML declaration.
+func encodeSitemapIndexHeader(w io.Writer, s *SitemapIndex, header string) error {
+	e := &sitemapEncoder{
+		w:       w,
+		header:  header,
+		scratch: make([]byte, 0, 256),
+	}
+	return e.encodeIndex(s)
+}
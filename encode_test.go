@@ -0,0 +1,213 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func testSitemap(n int) *Sitemap {
+	sm := NewSitemap()
+	lastmod := time.Date(2014, time.March, 1, 12, 30, 0, 500, time.FixedZone("CET", 3600))
+	priority := 0.8
+	for i := 0; i < n; i++ {
+		e := &Entry{
+			FileReference: &FileReference{
+				Location: fmt.Sprintf("http://example.com/documents/%d?a=1&b=<\"'>\t", i),
+			},
+		}
+		if i%2 == 0 {
+			e.LastModification = &lastmod
+			e.ChangeFrequency = Daily
+			e.Priority = &priority
+		}
+		sm.Entries = append(sm.Entries, e)
+	}
+	return sm
+}
+
+func TestEncodeSitemap(t *testing.T) {
+	alternates := testSitemap(2)
+	alternates.Schema = XHTMLSitemapSchema
+	alternates.Entries[1].Alternates = []*Alternate{
+		HreflangAlternate("fr", "http://example.com/fr/documents/1"),
+		AMPAlternate("http://example.com/documents/1/amp?a=1&b=2"),
+	}
+	extended := testSitemap(1)
+	extended.Schema = &Schema{
+		Xmlns: SitemapSchema.Xmlns,
+		Extra: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns:image"}, Value: "http://www.google.com/schemas/sitemap-image/1.1"},
+		},
+	}
+	for _, sm := range []*Sitemap{testSitemap(0), testSitemap(3), alternates, extended} {
+		expected := new(bytes.Buffer)
+		err := encodeXML(expected, sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := new(bytes.Buffer)
+		err = encodeSitemap(actual, sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.String() != expected.String() {
+			t.Errorf("Expecting:\n%s\nbut got:\n%s", expected, actual)
+		}
+	}
+}
+
+func TestEncodeSitemapIndex(t *testing.T) {
+	lastmod := time.Date(2014, time.March, 1, 12, 30, 0, 500, time.FixedZone("CET", 3600))
+	withLastmod := NewSitemapIndex([]string{"http://example.com/sitemap_1.xml", "http://example.com/sitemap_2.xml?a&b"})
+	for _, ref := range withLastmod.SitemapRefs {
+		ref.LastModification = &lastmod
+	}
+	for _, index := range []*SitemapIndex{NewSitemapIndex(nil), NewSitemapIndex([]string{"http://example.com/sitemap_1.xml"}), withLastmod} {
+		expected := new(bytes.Buffer)
+		err := encodeXML(expected, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := new(bytes.Buffer)
+		err = encodeSitemapIndex(actual, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.String() != expected.String() {
+			t.Errorf("Expecting:\n%s\nbut got:\n%s", expected, actual)
+		}
+	}
+}
+
+func TestLastModPrecision(t *testing.T) {
+	sm := testSitemap(3)
+	sm.Entries[2].LastModPrecision = DayPrecision
+	sm.Entries[0].LastModPrecision = SecondPrecision
+	out := new(bytes.Buffer)
+	err := encodeSitemap(out, sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"<lastmod>2014-03-01T12:30:00+01:00</lastmod>", "<lastmod>2014-03-01</lastmod>"} {
+		if !bytes.Contains(out.Bytes(), []byte(expected)) {
+			t.Errorf("Sitemap should contain %s:\n%s", expected, out)
+		}
+	}
+}
+
+func TestEncodePriority(t *testing.T) {
+	for priority, expected := range map[float64]string{1: "1", 0.5: "0.5", 0.75: "0.75", 0.25: "0.25", 0: "0"} {
+		p := priority
+		sm := testSitemap(1)
+		sm.Entries[0].Priority = &p
+		fast, slow := new(bytes.Buffer), new(bytes.Buffer)
+		err := encodeSitemap(fast, sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = encodeXML(slow, sm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fast.String() != slow.String() {
+			t.Errorf("Expecting:\n%s\nbut got:\n%s", slow, fast)
+		}
+		if !bytes.Contains(fast.Bytes(), []byte("<priority>"+expected+"</priority>")) {
+			t.Errorf("Priority %v should be written %s:\n%s", priority, expected, fast)
+		}
+	}
+}
+
+func TestPriorityPrecision(t *testing.T) {
+	for precision, expected := range map[int]string{0: "0.8", 2: "0.80", 3: "0.125"} {
+		storage := NewMemoryStorage()
+		b := NewStorageBuffer("http://example.com", storage)
+		b.PriorityPrecision = precision
+		p := 0.8
+		if precision == 3 {
+			p = 0.125
+		}
+		e := newEntry("http://example.com/a")
+		e.Priority = &p
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := readFile(storage, "sitemap_1.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte("<priority>"+expected+"</priority>")) {
+			t.Errorf("Expecting the priority %s with precision %d:\n%s", expected, precision, data)
+		}
+	}
+}
+
+func TestOmitDefaultPriority(t *testing.T) {
+	storage := NewMemoryStorage()
+	b := NewStorageBuffer("http://example.com", storage)
+	b.OmitDefaultPriority = true
+	for _, p := range []float64{0.5, 0.8} {
+		p := p
+		e := newEntry(fmt.Sprintf("http://example.com/%v", p))
+		e.Priority = &p
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := readFile(storage, "sitemap_1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(data, []byte("<priority>")) != 1 || !bytes.Contains(data, []byte("<priority>0.8</priority>")) {
+		t.Errorf("Expecting only the priority 0.8:\n%s", data)
+	}
+}
+
+func TestEncodeInvalidValues(t *testing.T) {
+	invalid := 1.5
+	sm := testSitemap(1)
+	sm.Entries[0].Priority = &invalid
+	frequency := testSitemap(1)
+	frequency.Entries[0].ChangeFrequency = "daily "
+	for _, s := range []*Sitemap{sm, frequency} {
+		if err := encodeSitemap(ioutil.Discard, s); err == nil {
+			t.Error("encodeSitemap should fail")
+		}
+		if err := encodeXML(ioutil.Discard, s); err == nil {
+			t.Error("encodeXML should fail")
+		}
+	}
+	if !Daily.Valid() || ChangeFrequency("sometimes").Valid() {
+		t.Error("Valid should only accept the frequencies of the protocol")
+	}
+}
+
+func BenchmarkEncodeSitemap(b *testing.B) {
+	sm := testSitemap(1000)
+	out := new(bytes.Buffer)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		encodeSitemap(out, sm)
+	}
+}
+
+func BenchmarkEncodeSitemapXML(b *testing.B) {
+	sm := testSitemap(1000)
+	out := new(bytes.Buffer)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		encodeXML(out, sm)
+	}
+}
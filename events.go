@@ -0,0 +1,100 @@
+package sitemap
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event is an event of the lifecycle of the sitemaps, published on Options.Events:
+// GenerationStarted, ShardWritten, EntryRejected, GenerationFinished or ServeError.
+type Event interface {
+	event()
+}
+
+// GenerationStarted is published at the start of every generation.
+type GenerationStarted struct {
+	Start time.Time
+}
+
+// ShardWritten is published once the sitemaps of a shard (see Router.Tag) are written.
+type ShardWritten struct {
+	Shard ShardStats
+}
+
+// EntryRejected is published for every invalid entry left out of the sitemaps, see Options.RejectInvalid.
+type EntryRejected struct {
+	Reject Reject
+}
+
+// GenerationFinished is published at the end of every generation.
+// Manifest is the manifest of the generation, nil if it failed with Err.
+type GenerationFinished struct {
+	Start    time.Time
+	Duration time.Duration
+	Manifest *Manifest
+	Err      error
+}
+
+// ServeError is published when a sitemap handler replies 503 to the request for Path:
+// Err is the error of the generation triggered by the request, nil if the files don't exist yet in read-only mode.
+// It is also published when ExportHandler fails part-way through, with the error of the storage or of the reply.
+type ServeError struct {
+	Path string
+	Err  error
+}
+
+func (GenerationStarted) event()  {}
+func (ShardWritten) event()       {}
+func (EntryRejected) event()      {}
+func (GenerationFinished) event() {}
+func (ServeError) event()         {}
+
+// EventBus publishes the events of Options.Events to its subscribers, so that applications can react to them
+// (alerting, cache purges, pings...) without the package knowing every integration. Its zero value is ready to use.
+//
+// Subscribers are called synchronously, in the order of subscription, possibly with the lock of the sitemaps held:
+// they must not call the Router, and should hand slow work over to another goroutine.
+type EventBus struct {
+	mutex       sync.Mutex
+	next        int
+	subscribers map[int]func(Event)
+}
+
+// Subscribe calls f with every event published from now on, until the returned function is called.
+func (b *EventBus) Subscribe(f func(Event)) (unsubscribe func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(Event))
+	}
+	id := b.next
+	b.next++
+	b.subscribers[id] = f
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// publish calls the subscribers of b with e. b may be nil.
+func (b *EventBus) publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	ids := make([]int, 0, len(b.subscribers))
+	for id := range b.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	subscribers := make([]func(Event), len(ids))
+	for i, id := range ids {
+		subscribers[i] = b.subscribers[id]
+	}
+	b.mutex.Unlock()
+	for _, f := range subscribers {
+		f(e)
+	}
+}
@@ -0,0 +1,107 @@
+package sitemap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// jsonEntry is the JSON form of an Entry.
+type jsonEntry struct {
+	Location         string           `json:"loc"`
+	LastModification *time.Time       `json:"lastmod,omitempty"`
+	ChangeFrequency  ChangeFrequency  `json:"changefreq,omitempty"`
+	Priority         *float64         `json:"priority,omitempty"`
+	Alternates       []*jsonAlternate `json:"alternates,omitempty"`
+}
+
+type jsonAlternate struct {
+	Rel      string `json:"rel"`
+	Hreflang string `json:"hreflang,omitempty"`
+	Href     string `json:"href"`
+}
+
+func newJSONEntry(e *Entry) *jsonEntry {
+	j := &jsonEntry{
+		ChangeFrequency: e.ChangeFrequency,
+		Priority:        e.Priority,
+	}
+	if e.FileReference != nil {
+		j.Location = e.Location
+		j.LastModification = e.LastModification
+	}
+	for _, a := range e.Alternates {
+		j.Alternates = append(j.Alternates, &jsonAlternate{Rel: a.Rel, Hreflang: a.Hreflang, Href: a.Href})
+	}
+	return j
+}
+
+// MarshalJSON encodes the entries of s as {"urls": [{"loc": ..., "lastmod": ..., "changefreq": ..., "priority": ...}, ...]},
+// with the names of the XML elements. Optional fields are omitted if unset.
+func (s *Sitemap) MarshalJSON() ([]byte, error) {
+	entries := make([]*jsonEntry, len(s.Entries))
+	for i, e := range s.Entries {
+		entries[i] = newJSONEntry(e)
+	}
+	return json.Marshal(struct {
+		URLs []*jsonEntry `json:"urls"`
+	}{entries})
+}
+
+// ExportHandler returns an http.Handler replying all entries of the current generation in JSON,
+// in the format of Sitemap.MarshalJSON, as if they were in a single sitemap. The entries are streamed
+// from the storage, so the size of the reply is not bounded.
+//
+// The handler is not registered anywhere: mount it on a private route.
+func (r *Router) ExportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, err := r.Manifest(); err != nil {
+			http.Error(w, "sitemaps unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		sent := &sentWriter{w: w}
+		out := bufio.NewWriter(sent)
+		encoder := json.NewEncoder(out)
+		out.WriteString(`{"urls":[`)
+		first := true
+		var writeErr error
+		err := r.walkEntries(func(name string, e *Entry) bool {
+			if !first {
+				out.WriteString(",")
+			}
+			first = false
+			writeErr = encoder.Encode(newJSONEntry(e))
+			return writeErr == nil
+		})
+		if err == nil {
+			err = writeErr
+		}
+		if err == nil {
+			out.WriteString("]}\n")
+			err = out.Flush()
+		}
+		if err != nil {
+			r.options().Events.publish(ServeError{Path: req.URL.Path, Err: err})
+			if !sent.sent {
+				http.Error(w, "export failed: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			// never end a truncated reply as if it was complete
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
+
+// sentWriter is a writer recording whether anything was written to w.
+type sentWriter struct {
+	w    io.Writer
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.w.Write(p)
+}
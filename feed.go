@@ -0,0 +1,151 @@
+package sitemap
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/xml"
+	"sort"
+	"time"
+)
+
+// Feed configures the Atom and RSS feeds of the most recently modified entries, written as atom.xml and rss.xml
+// next to the sitemaps. Only entries with a lastmod are part of the feeds.
+type Feed struct {
+	Title      string                // title of the feeds
+	Entries    int                   // number of entries in the feeds
+	EntryTitle func(e *Entry) string // title of an entry, its location if nil
+}
+
+const (
+	atom_file = "atom.xml"
+	rss_file  = "rss.xml"
+)
+
+// recentEntries keeps the most recently modified entries, as a min-heap on lastmod.
+type recentEntries struct {
+	max     int
+	entries []*Entry
+}
+
+func (r *recentEntries) Len() int { return len(r.entries) }
+func (r *recentEntries) Less(i, j int) bool {
+	return r.entries[i].LastModification.Before(*r.entries[j].LastModification)
+}
+func (r *recentEntries) Swap(i, j int)      { r.entries[i], r.entries[j] = r.entries[j], r.entries[i] }
+func (r *recentEntries) Push(x interface{}) { r.entries = append(r.entries, x.(*Entry)) }
+func (r *recentEntries) Pop() interface{} {
+	last := r.entries[len(r.entries)-1]
+	r.entries = r.entries[:len(r.entries)-1]
+	return last
+}
+
+// add keeps e if it is among the most recent entries.
+func (r *recentEntries) add(e *Entry) {
+	if e.LastModification == nil || r.max <= 0 {
+		return
+	}
+	if len(r.entries) < r.max {
+		heap.Push(r, e)
+	} else if e.LastModification.After(*r.entries[0].LastModification) {
+		r.entries[0] = e
+		heap.Fix(r, 0)
+	}
+}
+
+// sorted returns the entries, most recent first.
+func (r *recentEntries) sorted() []*Entry {
+	entries := append([]*Entry(nil), r.entries...)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastModification.After(*entries[j].LastModification)
+	})
+	return entries
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Link    atomLink `xml:"link"`
+	Updated string   `xml:"updated"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Link    atomLink     `xml:"link"`
+	Updated string       `xml:"updated"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+type rssFeed struct {
+	XMLName     xml.Name   `xml:"rss"`
+	Version     string     `xml:"version,attr"`
+	Title       string     `xml:"channel>title"`
+	Link        string     `xml:"channel>link"`
+	Description string     `xml:"channel>description"`
+	Items       []*rssItem `xml:"channel>item"`
+}
+
+// writeFeeds writes the Atom and RSS feeds of entries (most recent first) into s, and returns their description.
+// home is the url of the site, updated the time of the feeds.
+func (f *Feed) writeFeeds(s Storage, home string, entries []*Entry, updated time.Time) ([]*ManifestFile, error) {
+	atom := &atomFeed{
+		Title:   f.Title,
+		ID:      home,
+		Link:    atomLink{Href: home},
+		Updated: updated.Format(time.RFC3339),
+	}
+	rss := &rssFeed{
+		Version:     "2.0",
+		Title:       f.Title,
+		Link:        home,
+		Description: f.Title,
+	}
+	for _, e := range entries {
+		title := e.Location
+		if f.EntryTitle != nil {
+			title = f.EntryTitle(e)
+		}
+		atom.Entries = append(atom.Entries, &atomEntry{
+			Title:   title,
+			ID:      e.Location,
+			Link:    atomLink{Href: e.Location},
+			Updated: e.LastModification.Format(time.RFC3339),
+		})
+		rss.Items = append(rss.Items, &rssItem{
+			Title:   title,
+			Link:    e.Location,
+			GUID:    e.Location,
+			PubDate: e.LastModification.Format(time.RFC1123Z),
+		})
+	}
+
+	var files []*ManifestFile
+	for _, feed := range []struct {
+		name string
+		data interface{}
+	}{{atom_file, atom}, {rss_file, rss}} {
+		data := new(bytes.Buffer)
+		err := encodeXML(data, feed.data)
+		if err != nil {
+			return nil, err
+		}
+		err = s.WriteFile(feed.name, data.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, newManifestFile(feed.name, data.Bytes(), len(entries)))
+	}
+	return files, nil
+}
@@ -0,0 +1,31 @@
+package sitemap
+
+// Filter decides whether an entry belongs to the sitemaps.
+type Filter func(e *Entry) bool
+
+// AddFilter adds f to the filters applied to every entry during generation (see Options.Filters),
+// e.g. to exclude drafts from all routes at once:
+//
+//	r.AddFilter(func(e *sitemap.Entry) bool {
+//	  return !strings.Contains(e.Location, "/draft/")
+//	})
+//
+// It is safe to call AddFilter while serving, the filter applies from the next generation.
+func (r *Router) AddFilter(f Filter) {
+	r.UpdateOptions(func(o *Options) {
+		// never append in place: the previous options may share the array
+		filters := make([]Filter, len(o.Filters), len(o.Filters)+1)
+		copy(filters, o.Filters)
+		o.Filters = append(filters, f)
+	})
+}
+
+// accept returns true if e passes all filters of o.
+func (o *Options) accept(e *Entry) bool {
+	for _, f := range o.Filters {
+		if !f(e) {
+			return false
+		}
+	}
+	return true
+}
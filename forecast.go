@@ -0,0 +1,76 @@
+package sitemap
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	max_sitemap_urls   = 50000 // maximum number of urls in a sitemap
+	max_index_sitemaps = 50000 // maximum number of sitemaps in an index
+)
+
+// Forecast estimates the growth of the sitemaps of a Router, see Router.Forecast().
+type Forecast struct {
+	URLs         int     // number of urls of the last successful generation
+	GrowthPerDay float64 // average growth of the number of urls per day (negative if shrinking)
+	Sitemaps     int     // number of sitemaps needed for URLs (without tags)
+	// NextSitemap is the estimated time at which one more sitemap will be needed, zero if the urls don't grow.
+	NextSitemap time.Time
+	// IndexFull is the estimated time at which the sitemap index will be full (50,000 sitemaps of 50,000 urls),
+	// zero if the urls don't grow. A single index can't reference more sitemaps.
+	IndexFull time.Time
+}
+
+// sitemapsFor returns the number of sitemaps needed for urls.
+func sitemapsFor(urls int) int {
+	return (urls + max_sitemap_urls - 1) / max_sitemap_urls
+}
+
+// Forecast estimates when more sitemaps will be needed, from the number of urls of the successful
+// generations of Stats().History (a linear regression, which may be far off for seasonal sites).
+// It returns an error if there are less than two successful generations, at different times, in the history.
+func (r *Router) Forecast() (*Forecast, error) {
+	var points []Generation
+	for _, g := range r.Stats().History {
+		if g.Error == nil {
+			points = append(points, g)
+		}
+	}
+	if len(points) < 2 {
+		return nil, errors.New("sitemap: not enough generations to forecast")
+	}
+
+	// least squares, with times in days since the first generation
+	origin := points[0].Start
+	var sumT, sumU, sumTT, sumTU float64
+	for _, g := range points {
+		t := g.Start.Sub(origin).Hours() / 24
+		u := float64(g.URLs)
+		sumT += t
+		sumU += u
+		sumTT += t * t
+		sumTU += t * u
+	}
+	n := float64(len(points))
+	denominator := n*sumTT - sumT*sumT
+	if denominator == 0 {
+		return nil, errors.New("sitemap: not enough generations to forecast")
+	}
+
+	last := points[len(points)-1]
+	f := &Forecast{
+		URLs:         last.URLs,
+		GrowthPerDay: (n*sumTU - sumT*sumU) / denominator,
+		Sitemaps:     sitemapsFor(last.URLs),
+	}
+	if f.GrowthPerDay > 0 {
+		when := func(urls int) time.Time {
+			days := float64(urls-last.URLs) / f.GrowthPerDay
+			return last.Start.Add(time.Duration(days * 24 * float64(time.Hour)))
+		}
+		f.NextSitemap = when(f.Sitemaps*max_sitemap_urls + 1)
+		f.IndexFull = when(max_index_sitemaps*max_sitemap_urls + 1)
+	}
+	return f, nil
+}
@@ -0,0 +1,169 @@
+package sitemap
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// forwardedParam returns the value of param (e.g. "host", "proto") in the first element
+// of the Forwarded header of r (RFC 7239), or "" if there is none.
+func forwardedParam(r *http.Request, param string) string {
+	header := r.Header.Get("Forwarded")
+	if header == "" {
+		return ""
+	}
+	first := strings.SplitN(header, ",", 2)[0]
+	for _, pair := range strings.Split(first, ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) == 2 && strings.EqualFold(kv[0], param) {
+			return strings.Trim(kv[1], `"`)
+		}
+	}
+	return ""
+}
+
+// forwardedHost returns the host requested by the client as reported by a reverse proxy,
+// in the Forwarded or X-Forwarded-Host header, or "" if there is none.
+func forwardedHost(r *http.Request) string {
+	if host := forwardedParam(r, "host"); host != "" {
+		return host
+	}
+	// a proxy chain may append hosts, the first one is the client's
+	return strings.TrimSpace(strings.SplitN(r.Header.Get("X-Forwarded-Host"), ",", 2)[0])
+}
+
+// forwardedProto returns the scheme used by the client as reported by a reverse proxy,
+// in the Forwarded or X-Forwarded-Proto header, or "" if there is none or it is neither http nor https.
+func forwardedProto(r *http.Request) string {
+	proto := forwardedParam(r, "proto")
+	if proto == "" {
+		proto = strings.TrimSpace(strings.SplitN(r.Header.Get("X-Forwarded-Proto"), ",", 2)[0])
+	}
+	proto = strings.ToLower(proto)
+	if proto != "http" && proto != "https" {
+		return ""
+	}
+	return proto
+}
+
+// hostAllowed returns true if host matches one of the allowed hosts.
+// An allowed host "*.example.com" matches all subdomains of example.com.
+func hostAllowed(host string, allowed []string) bool {
+	host = strings.ToLower(host)
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if host == a || strings.HasPrefix(a, "*.") && strings.HasSuffix(host, a[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// domainScheme returns the scheme of domain, "http" if it has none.
+func domainScheme(domain string) string {
+	if i := strings.Index(domain, "://"); i > 0 {
+		return domain[:i]
+	}
+	return "http"
+}
+
+// domainHost returns the host of domain (with its port, if any).
+func domainHost(domain string) string {
+	if i := strings.Index(domain, "://"); i >= 0 {
+		domain = domain[i+len("://"):]
+	}
+	if i := strings.IndexByte(domain, '/'); i >= 0 {
+		domain = domain[:i]
+	}
+	return domain
+}
+
+// withScheme returns domain with its scheme replaced by scheme.
+func withScheme(domain, scheme string) string {
+	if i := strings.Index(domain, "://"); i > 0 {
+		return scheme + domain[i:]
+	}
+	return scheme + "://" + domain
+}
+
+// requestScheme returns the scheme of the request as received by the server.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// forwardedPrefix returns the path prefix under which a reverse proxy mounts the application,
+// as reported in the X-Forwarded-Prefix header, without trailing slash. It returns "" if there is none.
+func forwardedPrefix(r *http.Request) string {
+	prefix := strings.TrimSpace(strings.SplitN(r.Header.Get("X-Forwarded-Prefix"), ",", 2)[0])
+	prefix = strings.TrimRight(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return prefix
+}
+
+// errNoDomain is the error of lazy generations without domain: Options.Domain is empty,
+// and the host of the request is not allowed to set it.
+var errNoDomain = errors.New("sitemap: unknown domain (set Options.Domain, or Options.AllowedHosts to derive it from the requests)")
+
+// requestDomain returns the domain of request r (scheme and Host), or "" if its host (without port)
+// is not in opts.AllowedHosts: an arbitrary Host header must never become the domain of the sitemaps.
+func requestDomain(opts *Options, r *http.Request) string {
+	if r.Host == "" || !hostAllowed(normalizeHost(r.Host), opts.AllowedHosts) {
+		return ""
+	}
+	return requestScheme(r) + "://" + r.Host
+}
+
+// deriveDomain sets the domain of r from request req if Options.Domain is empty and the host of req is allowed,
+// so that later generations (GenerateSitemaps, Manager.Run) use it too. It returns the current options.
+func (r *Router) deriveDomain(req *http.Request) *Options {
+	if options := r.options(); options.Domain != "" || requestDomain(options, req) == "" {
+		return options
+	}
+	r.optionsMutex.Lock()
+	defer r.optionsMutex.Unlock()
+	if r.Options.Domain == "" {
+		if domain := requestDomain(r.Options, req); domain != "" {
+			options := new(Options)
+			*options = *r.Options
+			options.Domain = domain
+			r.Options = options
+		}
+	}
+	return r.Options
+}
+
+// requestOptions returns the options to generate sitemaps lazily on request r.
+//
+// If opts.Domain is empty, it is derived from the scheme and Host of the request, if the host is allowed (see requestDomain).
+// If opts.TrustForwardedHost is set and the forwarded host is allowed, the domain is taken from the request.
+// If opts.TrustForwardedProto is set, the scheme of the domain is the one reported by the proxy.
+// If opts.TrustForwardedPrefix is set and the request has a prefix, it replaces opts.ExternalPrefix.
+func requestOptions(opts *Options, r *http.Request) *Options {
+	o := new(Options)
+	*o = *opts
+	if o.Domain == "" {
+		o.Domain = requestDomain(opts, r)
+	}
+	if opts.TrustForwardedHost {
+		if host := forwardedHost(r); host != "" && hostAllowed(host, opts.AllowedHosts) {
+			o.Domain = domainScheme(o.Domain) + "://" + host
+		}
+	}
+	if opts.TrustForwardedProto && o.Domain != "" {
+		if proto := forwardedProto(r); proto != "" {
+			o.Domain = withScheme(o.Domain, proto)
+		}
+	}
+	if opts.TrustForwardedPrefix {
+		if prefix := forwardedPrefix(r); prefix != "" {
+			o.ExternalPrefix = prefix
+		}
+	}
+	return o
+}
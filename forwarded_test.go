@@ -0,0 +1,164 @@
+package sitemap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequestOptions(t *testing.T) {
+	opts := *DefaultOptions
+	opts.Domain = "https://internal.local"
+	opts.TrustForwardedHost = true
+	opts.AllowedHosts = []string{"example.com", "*.example.org"}
+
+	tests := []struct {
+		header, value string
+		domain        string
+	}{
+		{"X-Forwarded-Host", "example.com", "https://example.com"},
+		{"X-Forwarded-Host", "www.example.org, proxy.local", "https://www.example.org"},
+		{"Forwarded", `for=192.0.2.60;proto=http;host="example.com", for=198.51.100.17`, "https://example.com"},
+		{"X-Forwarded-Host", "evil.com", "https://internal.local"},
+		{"X-Forwarded-Host", "example.org.evil.com", "https://internal.local"},
+		{"", "", "https://internal.local"},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "http://internal.local/sitemapindex.xml", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		domain := requestOptions(&opts, r).Domain
+		if domain != test.domain {
+			t.Errorf("%s: %s gives domain %s instead of %s", test.header, test.value, domain, test.domain)
+		}
+	}
+}
+
+func TestForwardedPrefix(t *testing.T) {
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.TrustForwardedPrefix = true
+
+	r, err := http.NewRequest("GET", "http://example.com/sitemapindex.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Forwarded-Prefix", "blog/")
+
+	o := requestOptions(&opts, r)
+	if o.ExternalPrefix != "/blog" {
+		t.Fatalf("Expecting prefix /blog but got %s", o.ExternalPrefix)
+	}
+	if loc := fullLocation(o, "/post"); loc != "http://example.com/blog/post" {
+		t.Errorf("Expecting http://example.com/blog/post but got %s", loc)
+	}
+}
+
+func TestDomainDetection(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://example.com:8080/sitemapindex.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := *DefaultOptions
+	o := requestOptions(&opts, r)
+	if o.Domain != "" {
+		t.Errorf("The domain should not be derived from a host not allowed, got %s", o.Domain)
+	}
+	opts.AllowedHosts = []string{"example.com"}
+	o = requestOptions(&opts, r)
+	if o.Domain != "http://example.com:8080" {
+		t.Errorf("Expecting domain http://example.com:8080 but got %s", o.Domain)
+	}
+}
+
+func TestDerivedDomain(t *testing.T) {
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "", "")
+	r.Options.Storage = storage
+	r.Options.AllowedHosts = []string{"example.com"}
+	r.Register("/a")
+	handler := r.SitemapHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://evil.example/sitemapindex.xml", nil))
+	if domain := r.options().Domain; domain != "" {
+		t.Fatalf("The domain should not be derived from evil.example, got %s", domain)
+	}
+	if w.Code != http.StatusServiceUnavailable || fileExists(storage, "sitemapindex.xml") {
+		t.Fatalf("Expecting 503 without generating, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/sitemapindex.xml", nil))
+	if domain := r.options().Domain; domain != "http://example.com" {
+		t.Fatalf("Expecting the domain http://example.com to be stored, got %s", domain)
+	}
+	_, err := r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readFile(storage, "sitemap_1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<loc>http://example.com/a</loc>") {
+		t.Errorf("Later generations should use the derived domain:\n%s", data)
+	}
+}
+
+func TestDomainWithoutAllowedHosts(t *testing.T) {
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "", "")
+	r.Options.Storage = storage
+	r.Options.Events = new(EventBus)
+	var errs []error
+	r.Options.Events.Subscribe(func(e Event) {
+		if serveErr, ok := e.(ServeError); ok {
+			errs = append(errs, serveErr.Err)
+		}
+	})
+	r.Register("/a")
+	handler := r.SitemapHandler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/sitemapindex.xml", nil))
+	if w.Code != http.StatusServiceUnavailable || fileExists(storage, "sitemapindex.xml") {
+		t.Fatalf("Expecting 503 without generating, got %d", w.Code)
+	}
+	if domain := r.options().Domain; domain != "" {
+		t.Errorf("The domain should not be derived without AllowedHosts, got %s", domain)
+	}
+	if len(errs) != 1 || errs[0] != errNoDomain {
+		t.Errorf("Expecting the error %v but got %v", errNoDomain, errs)
+	}
+}
+
+func TestForwardedProto(t *testing.T) {
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.TrustForwardedProto = true
+
+	for header, value := range map[string]string{
+		"X-Forwarded-Proto": "https",
+		"Forwarded":         "proto=https;host=example.com",
+	} {
+		r, err := http.NewRequest("GET", "http://example.com/sitemapindex.xml", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set(header, value)
+
+		o := requestOptions(&opts, r)
+		if o.Domain != "https://example.com" {
+			t.Errorf("%s: expecting domain https://example.com but got %s", header, o.Domain)
+		}
+	}
+}
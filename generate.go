@@ -0,0 +1,597 @@
+package sitemap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Source enumerates sitemap entries, calling emit once per entry.
+// Locations must be full urls (domain included). They may be IRIs (with non-ASCII characters):
+// they are converted to URIs during generation.
+//
+// Each route registered on a Router is a Source. Sources can also be used without any Router,
+// see GenerateToDir().
+type Source func(emit func(*Entry) error) error
+
+// EntrySource returns a Source yielding the given entries.
+func EntrySource(entries ...*Entry) Source {
+	return func(emit func(*Entry) error) error {
+		for _, e := range entries {
+			err := emit(e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// GenerateToDir writes the sitemaps of all sources into dir, exactly as Router.GenerateSitemaps() does,
+// but without the need of a Router or an HTTP server (e.g. for static site builds or cron jobs).
+// The directory is created if needed.
+//
+// The options are used as by a Router, except opts.CachePath and opts.Storage which are replaced by dir.
+// Use DefaultOptions (with the Domain set) if unsure. The sitemap urls in the index are
+// opts.Domain + opts.ExternalPrefix + opts.ServerPath + file name.
+//
+// All files created is returned (paths relative to dir).
+func GenerateToDir(sources []Source, opts *Options, dir string) ([]string, error) {
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	err := os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	o := *opts
+	o.CachePath = dir
+	o.Storage = nil
+	if o.LowercasePaths {
+		lowercased := make([]Source, len(sources))
+		for i, source := range sources {
+			lowercased[i] = lowercasePaths(source)
+		}
+		sources = lowercased
+	}
+	manifest, err := generate([]*shard{{sources: sources}}, &o)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.fileNames(), nil
+}
+
+// recent_sitemap_name is the name of the buffer of Options.RecentWindow.
+const recent_sitemap_name = "recent"
+
+// generate writes the sitemapindex, the sitemaps and the manifest of all shards into the storage of opts.
+// Each shard gets its own sitemaps. The generation is published on opts.Events.
+func generate(shards []*shard, opts *Options) (*Manifest, error) {
+	start := opts.now()
+	opts.Events.publish(GenerationStarted{Start: start})
+	manifest, err := generateFiles(shards, opts)
+	opts.Events.publish(GenerationFinished{Start: start, Duration: opts.now().Sub(start), Manifest: manifest, Err: err})
+	return manifest, err
+}
+
+// generateFiles does the work of generate, in stages: each entry of the sources is normalized, filtered,
+// settled (rules, OnEntry...) and tracked (recent, delta), then added to the buffer of its shard;
+// once every source is enumerated, the collected entries (if any) are written, and the index and the manifest last.
+func generateFiles(shards []*shard, opts *Options) (*Manifest, error) {
+	g, err := newGeneration(shards, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = g.enumerate(shards)
+	if err != nil {
+		return nil, err
+	}
+	now := g.collected()
+	locations, files, shardStats, err := g.writeSitemaps()
+	if err != nil {
+		return nil, err
+	}
+	if g.staging != nil {
+		err = g.staging.publish()
+		if err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := g.writeIndex(now, locations, files, shardStats)
+	if err != nil {
+		return nil, err
+	}
+	g.end()
+	return manifest, nil
+}
+
+// generation is the state of a generation, see generateFiles.
+type generation struct {
+	opts     *Options
+	storage  Storage
+	sitemaps Storage         // storage of the sitemaps, staging if they replace previous ones
+	staging  *stagingStorage // nil unless the sitemaps replace previous ones
+	start    time.Time
+
+	canonical *canonicalizer
+	patterns  Filter
+	rules     *ruleSet
+
+	// collect is true to collect every entry before writing any,
+	// to write the entries in a stable order, compare their popularity or probe them
+	collect bool
+	// recycle is true if no option keeps the entries once written: they are then taken from pooledEntries,
+	// and put back once their sitemap is written or they are left out
+	recycle      bool
+	buffers      []*Buffer      // buffer of each shard, then of the shards of the rules
+	shardIndex   map[string]int // index of the buffer of each shard
+	shardEntries [][]*Entry     // collected entries of each buffer
+	recent       *recentEntries
+	windowed     []*Entry // entries of the recent sitemap
+	changed      []*Entry // entries of the delta sitemap
+
+	count, dropped, clamped int
+	rejects                 []Reject
+
+	resumable         bool
+	interrupted, done *journal
+}
+
+// newGeneration prepares the generation of shards: it checks opts, compiles the filters and begins the trackers.
+func newGeneration(shards []*shard, opts *Options) (*generation, error) {
+	_, err := cleanServerPath(opts.ServerPath)
+	if err != nil {
+		return nil, err
+	}
+	g := &generation{
+		opts:       opts,
+		storage:    opts.storage(),
+		start:      opts.now(),
+		canonical:  newCanonicalizer(opts),
+		collect:    opts.Reproducible || opts.Popularity != nil || opts.Probe != nil,
+		shardIndex: make(map[string]int, len(shards)),
+		recent:     new(recentEntries),
+		resumable:  opts.resumable(),
+	}
+	g.recycle = !g.collect && opts.Feed == nil && opts.RecentWindow <= 0 && opts.Delta == nil && opts.OnEntry == nil
+	if opts.Retry != nil {
+		g.storage = &retryStorage{Storage: g.storage, retry: opts.Retry, clock: opts.clock()}
+	}
+	g.sitemaps = g.storage
+	if !opts.HashFileNames && fileExists(g.storage, opts.indexFile()) { // nothing to keep on first generations and in Plan()
+		g.staging = &stagingStorage{Storage: g.storage}
+		g.sitemaps = g.staging
+	}
+	g.patterns, err = patternFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+	g.rules, err = compileRules(opts.Rules)
+	if err != nil {
+		return nil, err
+	}
+	g.buffers = make([]*Buffer, len(shards))
+	g.shardEntries = make([][]*Entry, len(shards))
+	for i, s := range shards {
+		g.buffers[i] = g.newBuffer(s.name)
+		g.shardIndex[s.name] = i
+	}
+	if opts.Feed != nil {
+		g.recent.max = opts.Feed.Entries
+	}
+	if g.resumable {
+		g.interrupted, g.done = readJournal(g.storage), new(journal)
+	}
+	if opts.ChangeTracker != nil {
+		opts.ChangeTracker.begin()
+	}
+	if opts.Delta != nil {
+		opts.Delta.begin()
+	}
+	if opts.GracePeriod != nil {
+		opts.GracePeriod.begin()
+	}
+	return g, nil
+}
+
+// newBuffer returns the buffer of the sitemaps named name.
+func (g *generation) newBuffer(name string) *Buffer {
+	opts := g.opts
+	buffer := NewStorageBuffer(opts.Domain, g.sitemaps)
+	buffer.Name = name
+	buffer.FilePrefix = opts.SitemapPrefix
+	buffer.HashNames = opts.HashFileNames
+	buffer.Compression = opts.Compression
+	buffer.Schema = opts.Schema
+	buffer.Text = opts.TextSitemaps
+	buffer.XMLHeader, buffer.OmitXMLHeader = opts.XMLHeader, opts.OmitXMLHeader
+	buffer.PriorityPrecision = opts.PriorityPrecision
+	buffer.OmitDefaultPriority = opts.OmitDefaultPriority
+	if g.recycle {
+		buffer.release = releaseEntry
+	}
+	return buffer
+}
+
+// enumerate calls the sources of every shard, skipping the shards completed by an interrupted generation.
+func (g *generation) enumerate(shards []*shard) error {
+	for i, s := range shards {
+		if g.resumable {
+			if completed := g.interrupted.completed(i, s); completed != nil {
+				g.buffers[i].Locations, g.buffers[i].Files = completed.Locations, completed.Files
+				if g.staging != nil {
+					for _, f := range completed.Files {
+						g.staging.names = append(g.staging.names, f.Name)
+					}
+				}
+				g.count += completed.URLs
+				g.dropped += completed.DroppedURLs
+				g.clamped += completed.ClampedLastMods
+				g.rejects = append(g.rejects, completed.Rejects...)
+				g.done.Shards = append(g.done.Shards, completed)
+				continue
+			}
+		}
+		count, dropped, clamped, rejects := g.count, g.dropped, g.clamped, len(g.rejects)
+		emit := g.emitter(i)
+		for j, source := range s.sources {
+			if g.opts.GracePeriod != nil {
+				source = g.opts.GracePeriod.source(s.key(j), source, g.start)
+			}
+			err := source(emit)
+			if err != nil {
+				return err
+			}
+		}
+		if g.resumable {
+			err := g.buffers[i].Flush()
+			if err != nil {
+				return err
+			}
+			g.done.Shards = append(g.done.Shards, &journalShard{
+				Name:            s.name,
+				Locations:       g.buffers[i].Locations,
+				Files:           g.buffers[i].Files,
+				URLs:            g.count - count,
+				DroppedURLs:     g.dropped - dropped,
+				ClampedLastMods: g.clamped - clamped,
+				Rejects:         g.rejects[rejects:],
+			})
+			err = g.done.write(g.storage)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// emitter returns the emit function of the sources of the shard at index i.
+func (g *generation) emitter(i int) func(*Entry) error {
+	return func(e *Entry) error {
+		if e == nil || e.FileReference == nil {
+			if g.opts.RejectInvalid {
+				g.reject("", "empty location")
+				return nil
+			}
+			return fmt.Errorf("sitemap: entry without location")
+		}
+		e = g.normalize(e)
+		rule, ok := g.filter(e)
+		if !ok {
+			g.release(e)
+			return nil
+		}
+		if g.opts.MaxURLs > 0 && g.count >= g.opts.MaxURLs {
+			g.dropped++
+			g.release(e)
+			return nil
+		}
+		copied := e
+		e, shard := g.settle(e, rule, i)
+		if e == nil {
+			g.release(copied)
+			return nil
+		}
+		g.count++
+		g.track(e)
+		if g.collect {
+			g.shardEntries[shard] = append(g.shardEntries[shard], e)
+			return nil
+		}
+		return g.buffers[shard].AddEntry(e)
+	}
+}
+
+// normalize returns a copy of e with its location escaped and normalized as set by the options.
+func (g *generation) normalize(e *Entry) *Entry {
+	if g.recycle {
+		e = pooledCopy(e)
+	} else {
+		e = copyEntry(e)
+	}
+	e.Location = g.opts.TrailingSlash.apply(EscapeLoc(e.Location))
+	if e.LastModPrecision == NanosecondPrecision {
+		e.LastModPrecision = g.opts.LastModPrecision
+	}
+	if g.opts.LowercaseHosts {
+		e.Location = lowercaseHost(e.Location)
+	}
+	return e
+}
+
+// release puts e, a copy made by normalize, back into pooledEntries if the entries are recycled.
+func (g *generation) release(e *Entry) {
+	if g.recycle {
+		releaseEntry(e)
+	}
+}
+
+// filter returns whether e is kept by the filters, and the rule matching it, if any.
+func (g *generation) filter(e *Entry) (*Rule, bool) {
+	if !g.canonical.accept(e) || (g.patterns != nil && !g.patterns(e)) || !g.opts.accept(e) {
+		return nil, false
+	}
+	rule := g.rules.match(e)
+	if rule != nil && rule.Exclude {
+		return nil, false
+	}
+	return rule, true
+}
+
+// settle completes e, an entry of the shard at index i, with the options and rule, and returns the index of its buffer.
+// It returns a nil entry if OnEntry drops e, or if e is invalid and rejected.
+func (g *generation) settle(e *Entry, rule *Rule, i int) (*Entry, int) {
+	opts := g.opts
+	if opts.ClampLastMod && e.LastModification != nil && e.LastModification.After(g.start) {
+		g.clamped++
+		start := g.start
+		e.LastModification = &start
+	}
+	opts.addAlternates(e)
+	if opts.ChangeTracker != nil {
+		opts.ChangeTracker.apply(e)
+	}
+	shard := i
+	if rule != nil {
+		rule.apply(e)
+		if rule.Shard != "" {
+			shard = g.ruleShard(rule.Shard)
+		}
+	}
+	if opts.OnEntry != nil {
+		e = opts.OnEntry(e)
+		if e == nil {
+			return nil, shard
+		}
+	}
+	if opts.ClampPriority {
+		clampPriority(e)
+	}
+	if opts.RejectInvalid {
+		if reason := validateEntry(e); reason != "" {
+			location := ""
+			if e.FileReference != nil {
+				location = e.Location
+			}
+			g.reject(location, reason)
+			return nil, shard
+		}
+	}
+	return e, shard
+}
+
+// ruleShard returns the index of the buffer of the shard name of a rule, created if needed.
+func (g *generation) ruleShard(name string) int {
+	index, ok := g.shardIndex[name]
+	if !ok {
+		index = len(g.buffers)
+		g.shardIndex[name] = index
+		g.buffers = append(g.buffers, g.newBuffer(name))
+		g.shardEntries = append(g.shardEntries, nil)
+	}
+	return index
+}
+
+// reject records the rejection of an invalid entry.
+func (g *generation) reject(location, reason string) {
+	g.rejects = append(g.rejects, Reject{Location: location, Reason: reason})
+	g.opts.Events.publish(EntryRejected{Reject: g.rejects[len(g.rejects)-1]})
+}
+
+// track records e for the feeds, and, unless the entries are collected, for the recent and delta sitemaps.
+func (g *generation) track(e *Entry) {
+	g.recent.add(e)
+	if !g.collect {
+		g.trackChanges(e)
+	}
+}
+
+// trackChanges records e for the recent and delta sitemaps.
+func (g *generation) trackChanges(e *Entry) {
+	opts := g.opts
+	if opts.RecentWindow > 0 && e.LastModification != nil && !e.LastModification.Before(g.start.Add(-opts.RecentWindow)) {
+		g.windowed = append(g.windowed, e)
+	}
+	if opts.Delta != nil && opts.Delta.changed(e) {
+		g.changed = append(g.changed, e)
+	}
+}
+
+// collected processes the collected entries (probes, popularity, order), and adds the recent sitemap.
+// It returns the time of the generation.
+func (g *generation) collected() time.Time {
+	opts := g.opts
+	now := opts.now()
+	if opts.Probe != nil {
+		for i := range g.shardEntries {
+			kept := opts.Probe.filter(g.shardEntries[i], now, opts.httpClient())
+			g.count -= len(g.shardEntries[i]) - len(kept)
+			g.shardEntries[i] = kept
+		}
+	}
+	var entries []*Entry
+	for _, e := range g.shardEntries {
+		entries = append(entries, e...)
+	}
+	if opts.Popularity != nil {
+		opts.Popularity.apply(entries)
+	}
+	if g.collect {
+		for _, e := range entries {
+			g.trackChanges(e)
+		}
+	}
+	if opts.Reproducible {
+		for _, e := range g.shardEntries {
+			sortEntries(e)
+		}
+		sortEntries(g.windowed)
+		sortEntries(g.changed)
+		now = latestModification(entries)
+	}
+	if opts.RecentWindow > 0 {
+		g.buffers = append(g.buffers, g.newBuffer(recent_sitemap_name))
+		g.shardEntries = append(g.shardEntries, g.windowed)
+	}
+	return now
+}
+
+// writeSitemaps writes the sitemaps of every buffer, and the delta sitemap.
+// It returns the locations of the sitemaps of the index, the files written and the statistics of the shards.
+func (g *generation) writeSitemaps() ([]string, []*ManifestFile, []ShardStats, error) {
+	opts := g.opts
+	var locations []string
+	var files []*ManifestFile
+	var shardStats []ShardStats
+	for i, buffer := range g.buffers {
+		for _, e := range g.shardEntries[i] {
+			err := buffer.AddEntry(e)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		err := buffer.Flush()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		locations = append(locations, buffer.Locations...)
+		files = append(files, buffer.Files...)
+		if opts.RecentWindow == 0 || i < len(g.buffers)-1 {
+			shardStats = append(shardStats, newShardStats(buffer))
+			opts.Events.publish(ShardWritten{Shard: shardStats[len(shardStats)-1]})
+		}
+	}
+	if opts.Delta != nil {
+		// not referenced by the index
+		buffer := g.newBuffer(delta_sitemap_name)
+		for _, e := range g.changed {
+			err := buffer.AddEntry(e)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		err := buffer.Flush()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, buffer.Files...)
+	}
+	return locations, files, shardStats, nil
+}
+
+// writeIndex writes the index of the sitemaps at locations, the feeds, the single sitemap, the rejects and the manifest.
+func (g *generation) writeIndex(now time.Time, locations []string, files []*ManifestFile, shardStats []ShardStats) (*Manifest, error) {
+	opts, storage := g.opts, g.storage
+	fullLocations := make([]string, len(locations))
+	for i, loc := range locations {
+		fullLocations[i] = opts.Domain + opts.ExternalPrefix + opts.serverPath() + loc
+	}
+
+	index := NewSitemapIndex(fullLocations)
+	if !now.IsZero() {
+		for _, ref := range index.SitemapRefs {
+			ref.LastModification = &now
+			ref.LastModPrecision = opts.LastModPrecision
+		}
+	}
+	path := opts.indexFile()
+	if opts.SingleSitemap && path == single_sitemap_file {
+		return nil, fmt.Errorf("sitemap: the index can't be named %s with SingleSitemap", single_sitemap_file)
+	}
+	data := new(bytes.Buffer)
+	err := encodeSitemapIndexHeader(data, index, xmlHeader(opts.XMLHeader, opts.OmitXMLHeader))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Feed != nil {
+		feeds, err := opts.Feed.writeFeeds(storage, opts.Domain+opts.ExternalPrefix+"/", g.recent.sorted(), now)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, feeds...)
+	}
+	if opts.SingleSitemap {
+		single, err := writeSingleSitemap(storage, locations, data.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if single.URLs < 0 {
+			single.URLs = g.count
+		}
+		files = append(files, single)
+	}
+	err = storage.WriteFile(path, data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	retained := 0
+	if opts.GracePeriod != nil {
+		retained = opts.GracePeriod.count()
+	}
+	manifest := &Manifest{
+		Generated:       now,
+		URLs:            g.count,
+		DroppedURLs:     g.dropped,
+		ClampedLastMods: g.clamped,
+		RejectedURLs:    len(g.rejects),
+		RetainedURLs:    retained,
+		Files:           append(files, newManifestFile(path, data.Bytes(), len(index.SitemapRefs))),
+		Shards:          shardStats,
+	}
+	if opts.RejectInvalid {
+		err = writeRejects(g.rejects, storage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writeManifest(manifest, storage, opts.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	if g.resumable {
+		// the generation is complete: nothing to resume
+		err = new(journal).write(storage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// end ends the trackers, once the generation is complete.
+func (g *generation) end() {
+	opts := g.opts
+	if opts.ChangeTracker != nil {
+		opts.ChangeTracker.end()
+	}
+	if opts.Delta != nil {
+		opts.Delta.end()
+	}
+	if opts.GracePeriod != nil {
+		opts.GracePeriod.end()
+	}
+}
@@ -0,0 +1,42 @@
+package sitemap
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// benchmarkGenerate generates the sitemaps of a parameterized route with n urls.
+func benchmarkGenerate(b *testing.B, n int) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.RegisterParam("/products/{id:[0-9]+}", func(cb func(...string) error) error {
+		for i := 0; i < n; i++ {
+			err := cb("id", strconv.Itoa(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := r.GenerateSitemaps()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGenerate10k(b *testing.B) { benchmarkGenerate(b, 10000) }
+func BenchmarkGenerate1M(b *testing.B)  { benchmarkGenerate(b, 1000000) }
@@ -0,0 +1,1190 @@
+package sitemap
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGenerateToDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	source := EntrySource(
+		&Entry{FileReference: &FileReference{Location: "http://example.com/a"}},
+		&Entry{FileReference: &FileReference{Location: "http://example.com/b"}},
+	)
+
+	out := filepath.Join(dir, "out")
+	files, err := GenerateToDir([]Source{source}, &opts, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expecting 3 files but got %v", files)
+	}
+
+	index := new(SitemapIndex)
+	mustReadXML(filepath.Join(out, "sitemapindex.xml"), index, t)
+	if len(index.SitemapRefs) != 1 || index.SitemapRefs[0].Location != "http://example.com/sitemap_1.xml" {
+		t.Fatalf("Unexpected sitemap index %v", index.SitemapRefs)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(out, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 2 {
+		t.Errorf("Expecting 2 but got %d urls in sitemap", len(sm.Entries))
+	}
+}
+
+func TestFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/posts/published")
+	r.Register("/posts/draft/next")
+	r.AddFilter(func(e *Entry) bool {
+		return !strings.Contains(e.Location, "/draft/")
+	})
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/posts/published" {
+		t.Errorf("Unexpected entries %v", sm.Entries)
+	}
+}
+
+func TestTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/")
+	r.Register("/en/blog")
+	r.Register("/fr/blog")
+	r.Register("/en/shop")
+	for pattern, tags := range map[string][]string{
+		"/en/blog": {"en", "blog"},
+		"/fr/blog": {"fr", "blog"},
+		"/en/shop": {"en"},
+	} {
+		err = r.Tag(pattern, tags...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Tag("/missing", "en") == nil {
+		t.Error("Tagging an unregistered route should fail")
+	}
+	if r.Tag("/", "Not valid") == nil {
+		t.Error("Invalid tags should be rejected")
+	}
+	for _, reserved := range []string{"recent", "delta"} {
+		if r.Tag("/", reserved) == nil {
+			t.Errorf("The reserved tag %s should be rejected", reserved)
+		}
+	}
+
+	files, err := r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"sitemap_1.xml", "sitemap_blog.en_1.xml", "sitemap_blog.fr_1.xml", "sitemap_en_1.xml", "sitemapindex.xml", "manifest.json"}
+	if strings.Join(files, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expecting files %v but got %v", expected, files)
+	}
+
+	handler := r.HandleSitemaps()
+	sm := new(Sitemap)
+	mustServeXML(handler, "http://example.com/sitemap_blog.en_1.xml", sm, t)
+	if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/en/blog" {
+		t.Errorf("Unexpected entries %v", sm.Entries)
+	}
+
+	r.UpdateOptions(func(o *Options) {
+		o.OnlyTags = []string{"en"}
+	})
+	files, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || files[0] != "sitemap_blog.en_1.xml" || files[1] != "sitemap_en_1.xml" {
+		t.Errorf("Unexpected files %v", files)
+	}
+}
+
+func TestAlternates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Alternates = AMPSuffix("/amp")
+	e := &Entry{FileReference: &FileReference{Location: "http://example.com/café"}}
+	e.Alternates = []*Alternate{HreflangAlternate("de", "http://example.com/de/café")}
+
+	for i := 0; i < 2; i++ {
+		// the entries of the source are reused
+		_, err = GenerateToDir([]Source{EntrySource(e)}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(e.Alternates) != 1 || e.Location != "http://example.com/café" {
+		t.Errorf("The entry of the source should not be modified, got %s with %d alternates", e.Location, len(e.Alternates))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "sitemap_1.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), `rel="amphtml"`); n != 1 {
+		t.Errorf("Expecting a single amphtml alternate, got %d:\n%s", n, data)
+	}
+	for _, s := range []string{
+		`xmlns:xhtml="http://www.w3.org/1999/xhtml"`,
+		`<xhtml:link rel="alternate" hreflang="de" href="http://example.com/de/caf%C3%A9"></xhtml:link>`,
+		`<xhtml:link rel="amphtml" href="http://example.com/caf%C3%A9/amp"></xhtml:link>`,
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("Sitemap should contain %s:\n%s", s, data)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := func() Source {
+		var entries []*Entry
+		for _, loc := range []string{"/a", "/a?sort=date", "/b?sort=date", "/c"} {
+			entries = append(entries, &Entry{FileReference: &FileReference{Location: "http://example.com" + loc}})
+		}
+		return EntrySource(entries...)
+	}
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Canonical = func(loc string) string {
+		return strings.TrimSuffix(loc, "?sort=date")
+	}
+
+	for _, test := range []struct {
+		replace  bool
+		expected []string
+	}{
+		{false, []string{"/a", "/c"}},
+		{true, []string{"/a", "/b", "/c"}},
+	} {
+		opts.ReplaceNonCanonical = test.replace
+		_, err = GenerateToDir([]Source{entries()}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := new(Sitemap)
+		mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+		var locs []string
+		for _, e := range sm.Entries {
+			locs = append(locs, strings.TrimPrefix(e.Location, "http://example.com"))
+		}
+		if strings.Join(locs, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("Expecting %v but got %v (replace %v)", test.expected, locs, test.replace)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/ok":
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := func() Source {
+		var entries []*Entry
+		for _, p := range []string{"/ok", "/moved", "/missing"} {
+			entries = append(entries, &Entry{FileReference: &FileReference{Location: server.URL + p}})
+		}
+		return EntrySource(entries...)
+	}
+	opts := *DefaultOptions
+	opts.Domain = server.URL
+	opts.Probe = &Probe{Concurrency: 2, TTL: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		_, err = GenerateToDir([]Source{source()}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := new(Sitemap)
+		mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+		if len(sm.Entries) != 1 || sm.Entries[0].Location != server.URL+"/ok" {
+			t.Errorf("Unexpected entries %v", sm.Entries)
+		}
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 3 {
+		t.Errorf("Expecting 3 requests (cached results) but got %d", requests)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Schema = &Schema{Xmlns: SitemapSchema.Xmlns}
+	e := &Entry{FileReference: &FileReference{Location: "http://example.com/"}}
+	e.Alternates = []*Alternate{AMPAlternate("http://example.com/amp")}
+
+	_, err = GenerateToDir([]Source{EntrySource(e)}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "sitemap_1.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:xhtml="http://www.w3.org/1999/xhtml">`
+	if !strings.Contains(string(data), expected) {
+		t.Errorf("Sitemap should contain %s:\n%s", expected, data)
+	}
+	if opts.Schema.XmlnsXhtml != "" || SitemapSchema.XmlnsXhtml != "" {
+		t.Error("Schemas should not be modified")
+	}
+}
+
+func TestChangeTracker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.ChangeTracker = new(ChangeTracker)
+	start := time.Date(2014, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	var sm *Sitemap
+	for day := 0; day < 3; day++ {
+		daily := start.Add(time.Duration(day) * 24 * time.Hour)
+		source := EntrySource(
+			&Entry{FileReference: &FileReference{Location: "http://example.com/daily", LastModification: &daily}},
+			&Entry{FileReference: &FileReference{Location: "http://example.com/static", LastModification: &start}},
+		)
+		_, err = GenerateToDir([]Source{source}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm = new(Sitemap)
+		mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+		if day == 0 && sm.Entries[0].ChangeFrequency != "" {
+			t.Errorf("Change frequency should be unknown after the first generation")
+		}
+	}
+	if sm.Entries[0].ChangeFrequency != Daily || sm.Entries[1].ChangeFrequency != "" {
+		t.Errorf("Unexpected change frequencies %q and %q", sm.Entries[0].ChangeFrequency, sm.Entries[1].ChangeFrequency)
+	}
+}
+
+func TestTextSitemaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.TextSitemaps = true
+	r.Options.Compression = DefaultCompression
+	r.Register("/about")
+	r.Register("/café")
+	files, err := r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, " ") != "sitemap_1.txt sitemap_1.xml.gz sitemapindex.xml manifest.json" {
+		t.Errorf("Unexpected files %v", files)
+	}
+
+	handler := r.HandleSitemaps()
+	req, err := http.NewRequest("GET", "http://example.com/sitemap_1.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if expected := "http://example.com/about\nhttp://example.com/caf%C3%A9\n"; w.Code != http.StatusOK || w.Body.String() != expected {
+		t.Errorf("Expecting %q but got %d %q", expected, w.Code, w.Body.String())
+	}
+}
+
+func TestFeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Feed = &Feed{Title: "Example", Entries: 2}
+	var entries []*Entry
+	start := time.Date(2014, time.March, 1, 0, 0, 0, 0, time.UTC)
+	for i, p := range []string{"/old", "/new", "/undated", "/newest", "/older"} {
+		e := &Entry{FileReference: &FileReference{Location: "http://example.com" + p}}
+		if p != "/undated" {
+			lastmod := start.Add(time.Duration(i) * time.Hour)
+			if p == "/older" {
+				lastmod = start.Add(-time.Hour)
+			}
+			e.LastModification = &lastmod
+		}
+		entries = append(entries, e)
+	}
+
+	_, err = GenerateToDir([]Source{EntrySource(entries...)}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atom := new(atomFeed)
+	mustReadXML(filepath.Join(dir, "atom.xml"), atom, t)
+	if len(atom.Entries) != 2 || atom.Entries[0].ID != "http://example.com/newest" || atom.Entries[1].ID != "http://example.com/new" {
+		t.Errorf("Unexpected atom entries %v", atom.Entries)
+	}
+	rss := new(rssFeed)
+	mustReadXML(filepath.Join(dir, "rss.xml"), rss, t)
+	if len(rss.Items) != 2 || rss.Title != "Example" || rss.Items[0].PubDate != "Sat, 01 Mar 2014 03:00:00 +0000" {
+		t.Errorf("Unexpected rss %+v", rss)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/public")
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		return cb("id", "a")
+	})
+	err = r.SetMetadata("/doc/{id}", "section", "internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddFilter(func(e *Entry) bool {
+		return e.Metadata["section"] != "internal"
+	})
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/public" {
+		t.Errorf("Unexpected entries %v", sm.Entries)
+	}
+}
+
+func TestURLBuilder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/internal/home")
+	r.RegisterParam("/internal/doc/{id}", func(cb func(...string) error) error {
+		return cb("id", "a")
+	})
+	vanity := func(route *mux.Route, pairs ...string) (*url.URL, error) {
+		u, err := route.URL(pairs...)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = strings.TrimPrefix(u.Path, "/internal")
+		return u, nil
+	}
+	for _, pattern := range []string{"/internal/home", "/internal/doc/{id}"} {
+		err = r.SetURLBuilder(pattern, vanity)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 2 || sm.Entries[0].Location != "http://example.com/home" || sm.Entries[1].Location != "http://example.com/doc/a" {
+		t.Errorf("Unexpected entries %v", sm.Entries)
+	}
+}
+
+func TestRouteError(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Options.Storage = NewMemoryStorage()
+	r.RegisterParam("/doc/{id:[0-9]+}", func(cb func(...string) error) error {
+		err := cb("id", "1")
+		if err != nil {
+			return err
+		}
+		return cb("id", "abc")
+	})
+
+	_, err := r.GenerateSitemaps()
+	routeErr, ok := err.(*RouteError)
+	if !ok {
+		t.Fatalf("Expecting a RouteError, got %v", err)
+	}
+	if routeErr.Pattern != "/doc/{id:[0-9]+}" || len(routeErr.Pairs) != 2 || routeErr.Pairs[1] != "abc" {
+		t.Errorf("The error should tell the route and the variables, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"abc"`) {
+		t.Errorf("Unexpected message %q", err)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Options.Storage = NewMemoryStorage()
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		for i := 0; ; i++ { // never ends unless cb fails
+			err := cb("id", strconv.Itoa(i))
+			if err != nil {
+				return err
+			}
+		}
+	})
+	err := r.SetMaxEntries("/doc/{id}", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.GenerateSitemaps()
+	if routeErr, ok := err.(*RouteError); !ok || routeErr.Err != ErrTooManyEntries {
+		t.Errorf("Expecting ErrTooManyEntries, got %v", err)
+	}
+}
+
+func TestRecentWindow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, time.June, 10, 0, 0, 0, 0, time.UTC)
+	old, fresh := now.AddDate(0, 0, -30), now.AddDate(0, 0, -2)
+	for _, reproducible := range []bool{false, true} {
+		opts := *DefaultOptions
+		opts.Domain = "http://example.com"
+		opts.Clock = &testClock{now}
+		opts.RecentWindow = 7 * 24 * time.Hour
+		opts.Reproducible = reproducible
+		source := EntrySource(
+			&Entry{FileReference: &FileReference{Location: "http://example.com/a", LastModification: &old}},
+			&Entry{FileReference: &FileReference{Location: "http://example.com/b", LastModification: &fresh}},
+			&Entry{FileReference: &FileReference{Location: "http://example.com/c"}},
+		)
+		_, err := GenerateToDir([]Source{source}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		index := new(SitemapIndex)
+		mustReadXML(filepath.Join(dir, "sitemapindex.xml"), index, t)
+		if len(index.SitemapRefs) != 2 || index.SitemapRefs[1].Location != "http://example.com/sitemap_recent_1.xml" {
+			t.Fatalf("Unexpected sitemap index %v", index.SitemapRefs)
+		}
+		sm := new(Sitemap)
+		mustReadXML(filepath.Join(dir, "sitemap_recent_1.xml"), sm, t)
+		if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/b" {
+			t.Errorf("Expecting only the recent entry, got %v", sm.Entries)
+		}
+	}
+}
+
+func TestRepresentations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	enumerations := 0
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		enumerations++
+		return cb("id", "a")
+	})
+	err = r.AddRepresentation("/doc/{id}", ".pdf", 0.3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.AddRepresentation("/doc/{id}", "/print", 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	expected := []string{"http://example.com/doc/a", "http://example.com/doc/a.pdf", "http://example.com/doc/a/print"}
+	if len(sm.Entries) != len(expected) || enumerations != 1 {
+		t.Fatalf("Expecting %v from one enumeration, got %v from %d", expected, sm.Entries, enumerations)
+	}
+	for i, e := range sm.Entries {
+		if e.Location != expected[i] {
+			t.Errorf("Expecting %s but got %s", expected[i], e.Location)
+		}
+	}
+	if *sm.Entries[1].Priority != 0.3 {
+		t.Errorf("Expecting priority 0.3 but got %v", *sm.Entries[1].Priority)
+	}
+}
+
+func TestOnEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, reproducible := range []bool{false, true} {
+		opts := *DefaultOptions
+		opts.Domain = "http://example.com"
+		opts.Reproducible = reproducible
+		opts.ClampPriority = true
+		opts.Feed = &Feed{Title: "Example", Entries: 10}
+		opts.OnEntry = func(e *Entry) *Entry {
+			if strings.HasSuffix(e.Location, "/hidden") {
+				return nil
+			}
+			e.Location = strings.Replace(e.Location, "http://example.com", "https://www.example.com", 1)
+			priority := 1.5
+			e.Priority = &priority
+			return e
+		}
+		lastmod := time.Date(2014, time.March, 1, 0, 0, 0, 0, time.UTC)
+		source := EntrySource(
+			&Entry{FileReference: &FileReference{Location: "http://example.com/b", LastModification: &lastmod}},
+			&Entry{FileReference: &FileReference{Location: "http://example.com/hidden", LastModification: &lastmod}},
+			&Entry{FileReference: &FileReference{Location: "http://example.com/a", LastModification: &lastmod}},
+		)
+		_, err := GenerateToDir([]Source{source}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := readManifest(DirStorage(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.URLs != 2 {
+			t.Errorf("Expecting 2 urls, got %d", m.URLs)
+		}
+		sm := new(Sitemap)
+		mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+		if len(sm.Entries) != 2 || !strings.HasPrefix(sm.Entries[0].Location, "https://www.example.com/") {
+			t.Errorf("Unexpected entries %v", sm.Entries)
+		}
+		for _, e := range sm.Entries {
+			if e.Priority == nil || *e.Priority != 1 {
+				t.Errorf("Expecting the priority set by OnEntry to be clamped, got %v", e.Priority)
+			}
+		}
+		atom := new(atomFeed)
+		mustReadXML(filepath.Join(dir, "atom.xml"), atom, t)
+		if len(atom.Entries) != 2 {
+			t.Errorf("Expecting the entries returned by OnEntry in the feed, got %v", atom.Entries)
+		}
+		for _, e := range atom.Entries {
+			if !strings.HasPrefix(e.ID, "https://www.example.com/") {
+				t.Errorf("Unexpected feed entry %v", e)
+			}
+		}
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// each router stands for a process, all sharing dir
+	newRouter := func() *Router {
+		r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+		r.Options.Delta = new(Delta) // not supported: partitions would overwrite each other's delta
+		for _, tag := range []string{"a", "b", "c"} {
+			r.Register("/" + tag)
+			err := r.Tag("/"+tag, tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r
+	}
+	for partition := 0; partition < 2; partition++ {
+		_, err := newRouter().GeneratePartition(partition, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	coordinator := newRouter()
+	files, err := coordinator.AssembleIndex(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Errorf("Expecting 3 sitemaps, the index and the manifest, got %v", files)
+	}
+
+	index := new(SitemapIndex)
+	mustReadXML(filepath.Join(dir, "sitemapindex.xml"), index, t)
+	var locations []string
+	for _, ref := range index.SitemapRefs {
+		locations = append(locations, ref.Location)
+	}
+	expected := []string{"http://example.com/sitemap_a_1.xml", "http://example.com/sitemap_c_1.xml", "http://example.com/sitemap_b_1.xml"}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("Expecting %v but got %v", expected, locations)
+	}
+	if coordinator.Stats().URLs != 3 {
+		t.Errorf("Expecting 3 urls, got %d", coordinator.Stats().URLs)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sitemap_delta_1.xml")); !os.IsNotExist(err) {
+		t.Errorf("Partitions should not write delta sitemaps: %v", err)
+	}
+
+	_, err = coordinator.AssembleIndex(3)
+	if err == nil {
+		t.Error("AssembleIndex should fail with a missing partition")
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.Resume = true
+	enumerations := map[string]int{}
+	failing := true
+	for _, tag := range []string{"a", "b"} {
+		tag := tag
+		r.RegisterParam("/"+tag+"/{id}", func(cb func(...string) error) error {
+			enumerations[tag]++
+			if tag == "b" && failing {
+				return errors.New("interrupted")
+			}
+			return cb("id", "1")
+		})
+		err := r.Tag("/"+tag+"/{id}", tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err == nil {
+		t.Fatal("The first generation should fail")
+	}
+	failing = false
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enumerations["a"] != 1 || enumerations["b"] != 2 {
+		t.Errorf("The completed shard should not be enumerated again, got %v", enumerations)
+	}
+	index := new(SitemapIndex)
+	mustReadXML(filepath.Join(dir, "sitemapindex.xml"), index, t)
+	if len(index.SitemapRefs) != 2 || r.Stats().URLs != 2 {
+		t.Errorf("Expecting the sitemaps of both shards, got %v", index.SitemapRefs)
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enumerations["a"] != 2 {
+		t.Errorf("A complete generation should not be resumed, got %v", enumerations)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Delta = new(Delta)
+	day1, day2 := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, time.June, 2, 0, 0, 0, 0, time.UTC)
+	generate := func(lastmod *time.Time, locations ...string) *Manifest {
+		var entries []*Entry
+		for _, loc := range locations {
+			entries = append(entries, &Entry{FileReference: &FileReference{Location: loc, LastModification: lastmod}})
+		}
+		entries[0].LastModification = &day1
+		_, err := GenerateToDir([]Source{EntrySource(entries...)}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := readManifest(DirStorage(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	generate(&day1, "http://example.com/a", "http://example.com/b")
+	m := generate(&day2, "http://example.com/a", "http://example.com/b", "http://example.com/c")
+	var names []string
+	for _, f := range m.Files {
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"sitemap_1.xml", "sitemap_delta_1.xml", "sitemapindex.xml"}) {
+		t.Fatalf("Unexpected files %v", names)
+	}
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_delta_1.xml"), sm, t)
+	if len(sm.Entries) != 2 || sm.Entries[0].Location != "http://example.com/b" || sm.Entries[1].Location != "http://example.com/c" {
+		t.Errorf("Expecting the modified and the added entries, got %v", sm.Entries)
+	}
+	index := new(SitemapIndex)
+	mustReadXML(filepath.Join(dir, "sitemapindex.xml"), index, t)
+	if len(index.SitemapRefs) != 1 {
+		t.Errorf("The delta should not be referenced by the index, got %v", index.SitemapRefs)
+	}
+
+	m = generate(&day2, "http://example.com/a", "http://example.com/b", "http://example.com/c")
+	if len(m.Files) != 2 {
+		t.Errorf("Expecting no delta without changes, got %d files", len(m.Files))
+	}
+}
+
+func TestRegenerateIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about")
+	r.Register("/contact")
+	r.Tag("/contact", "en")
+	r.Register("/faq")
+	r.Tag("/faq", "fr")
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sitemaps changed by an external tool
+	err = os.Remove(filepath.Join(dir, "sitemap_fr_1.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = testSitemap(3).WriteToFile(filepath.Join(dir, "sitemap_en_1.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.RegenerateIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := new(SitemapIndex)
+	mustReadXML(filepath.Join(dir, "sitemapindex.xml"), index, t)
+	var locations []string
+	for _, ref := range index.SitemapRefs {
+		locations = append(locations, ref.Location)
+	}
+	expected := []string{"http://example.com/sitemap_1.xml", "http://example.com/sitemap_en_1.xml"}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("Expecting the index of %v, got %v", expected, locations)
+	}
+	manifest, err := r.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.URLs != 4 {
+		t.Errorf("Expecting 4 urls in the manifest, got %d", manifest.URLs)
+	}
+	for _, f := range manifest.Files {
+		if f.Name == "sitemap_fr_1.xml" {
+			t.Error("The removed sitemap should not be in the manifest")
+		}
+		if f.Name == "sitemap_en_1.xml" && f.URLs != 3 {
+			t.Errorf("Expecting 3 urls in the uploaded sitemap, got %d", f.URLs)
+		}
+	}
+	if generations := r.Stats().Generations; generations != 2 {
+		t.Errorf("Expecting 2 generations, got %d", generations)
+	}
+}
+
+func TestShardStats(t *testing.T) {
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = storage
+	r.Options.TextSitemaps = true
+	r.Register("/about")
+	r.Register("/contact")
+	r.RegisterParam("/products/{id}", func(cb func(...string) error) error {
+		for i := 0; i < 3; i++ {
+			if err := cb("id", strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	r.Tag("/products/{id}", "products")
+	_, err := r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := r.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Shards) != 2 {
+		t.Fatalf("Expecting 2 shards, got %v", manifest.Shards)
+	}
+	for i, expected := range []struct {
+		name  string
+		urls  int
+		files []string
+	}{
+		{"", 2, []string{"sitemap_1.xml", "sitemap_1.txt"}},
+		{"products", 3, []string{"sitemap_products_1.xml", "sitemap_products_1.txt"}},
+	} {
+		var size int64
+		for _, name := range expected.files {
+			data, err := readFile(storage, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += int64(len(data))
+		}
+		shard := manifest.Shards[i]
+		if shard.Name != expected.name || shard.Sitemaps != 1 || shard.URLs != expected.urls || shard.Bytes != size {
+			t.Errorf("Expecting shard %q with 1 sitemap, %d urls and %d bytes, got %+v", expected.name, expected.urls, size, shard)
+		}
+	}
+	if stats := r.Stats(); !reflect.DeepEqual(stats.Shards, manifest.Shards) {
+		t.Errorf("Expecting the shards of the manifest in the stats, got %v", stats.Shards)
+	}
+}
+
+func TestClampLastMod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC)
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Clock = FixedClock(now)
+	opts.ClampLastMod = true
+	entries := []*Entry{
+		{FileReference: &FileReference{Location: "http://example.com/past", LastModification: &past}},
+		{FileReference: &FileReference{Location: "http://example.com/future", LastModification: &future}},
+	}
+	_, err = GenerateToDir([]Source{EntrySource(entries...)}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 2 {
+		t.Fatalf("Expecting 2 entries, got %d", len(sm.Entries))
+	}
+	if lastmod := sm.Entries[0].LastModification; lastmod == nil || !lastmod.Equal(past) {
+		t.Errorf("Expecting the lastmod in the past to be kept, got %v", lastmod)
+	}
+	if lastmod := sm.Entries[1].LastModification; lastmod == nil || !lastmod.Equal(now) {
+		t.Errorf("Expecting the lastmod in the future to be clamped to %v, got %v", now, lastmod)
+	}
+	m, err := readManifest(DirStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ClampedLastMods != 1 {
+		t.Errorf("Expecting 1 clamped lastmod in the manifest, got %d", m.ClampedLastMods)
+	}
+}
+
+func TestRejectInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	priority := 1.5
+	entries := []*Entry{
+		newEntry("http://example.com/valid"),
+		newEntry("/relative"),
+		{FileReference: &FileReference{Location: "http://example.com/priority"}, Priority: &priority},
+		{FileReference: &FileReference{Location: "http://example.com/changefreq"}, ChangeFrequency: "sometimes"},
+		{Priority: &priority}, // no location
+	}
+	_, err = GenerateToDir([]Source{EntrySource(entries...)}, &opts, dir)
+	if err == nil {
+		t.Fatal("Invalid entries should fail the generation by default")
+	}
+	_, err = GenerateToDir([]Source{EntrySource(entries[4])}, &opts, dir)
+	if err == nil {
+		t.Fatal("An entry without location should fail the generation by default")
+	}
+
+	opts.RejectInvalid = true
+	_, err = GenerateToDir([]Source{EntrySource(entries...)}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/valid" {
+		t.Errorf("Expecting only the valid entry, got %v", sm.Entries)
+	}
+
+	var rejects []Reject
+	data, err := ioutil.ReadFile(filepath.Join(dir, "rejects.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &rejects); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Reject{
+		{Location: "/relative", Reason: "malformed location"},
+		{Location: "http://example.com/priority", Reason: "priority 1.5 outside [0, 1]"},
+		{Location: "http://example.com/changefreq", Reason: `invalid change frequency "sometimes"`},
+		{Location: "", Reason: "empty location"},
+	}
+	if !reflect.DeepEqual(rejects, expected) {
+		t.Errorf("Expecting the rejects %v, got %v", expected, rejects)
+	}
+	m, err := readManifest(DirStorage(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.URLs != 1 || m.RejectedURLs != 4 {
+		t.Errorf("Expecting 1 url and 4 rejected urls in the manifest, got %d and %d", m.URLs, m.RejectedURLs)
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestHTTPClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Probe = new(Probe)
+	opts.HTTPClient = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		status := http.StatusOK
+		if r.URL.Path == "/missing" {
+			status = http.StatusNotFound
+		}
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+	})}
+	entries := []*Entry{newEntry("http://example.com/ok"), newEntry("http://example.com/missing")}
+	_, err = GenerateToDir([]Source{EntrySource(entries...)}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/ok" {
+		t.Errorf("Unexpected entries %v", sm.Entries)
+	}
+	if requests := atomic.LoadInt32(&requests); requests != 2 {
+		t.Errorf("Expecting the probe to use Options.HTTPClient, got %d requests", requests)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.SigningKey = private
+	_, err = GenerateToDir([]Source{EntrySource(newEntry("http://example.com/a"), newEntry("http://example.com/b"))}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Verify(DirStorage(dir), public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.URLs != 2 {
+		t.Errorf("Expecting the manifest of 2 urls, got %d", m.URLs)
+	}
+
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(DirStorage(dir), other); err != ErrInvalidSignature {
+		t.Errorf("Expecting ErrInvalidSignature with another key, got %v", err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "sitemap_1.xml"), []byte("<urlset/>"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(DirStorage(dir), public); err == nil || !strings.Contains(err.Error(), "sitemap_1.xml") {
+		t.Errorf("Expecting a tampered sitemap to fail the verification, got %v", err)
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := &testClock{time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)}
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Clock = clock
+	opts.GracePeriod = &GracePeriod{Generations: 2}
+	generate := func(locations ...string) (*Manifest, []*Entry) {
+		var entries []*Entry
+		for _, loc := range locations {
+			entries = append(entries, newEntry(loc))
+		}
+		_, err := GenerateToDir([]Source{EntrySource(entries...)}, &opts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := readManifest(DirStorage(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := new(Sitemap)
+		mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+		return m, sm.Entries
+	}
+
+	generate("http://example.com/a", "http://example.com/b")
+	missing := clock.now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		clock.now = missing.Add(time.Duration(i) * time.Hour)
+		m, entries := generate("http://example.com/a")
+		if m.RetainedURLs != 1 || len(entries) != 2 || entries[1].Location != "http://example.com/b" {
+			t.Fatalf("Expecting b to be retained in generation %d, got %v", i, entries)
+		}
+		if lastmod := entries[1].LastModification; lastmod == nil || !lastmod.Equal(missing) {
+			t.Errorf("Expecting the lastmod of b to be the start of the first generation missing it, got %v", lastmod)
+		}
+	}
+	m, entries := generate("http://example.com/a")
+	if m.RetainedURLs != 0 || len(entries) != 1 {
+		t.Errorf("Expecting b to be removed after the grace period, got %v", entries)
+	}
+}
@@ -0,0 +1,132 @@
+package sitemap
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// GracePeriod keeps the urls which disappear from their source in the sitemaps for a few generations,
+// see Options.GracePeriod, so that a transient failure of a data source (an empty result, a partial page)
+// doesn't remove and add back thousands of urls. The same GracePeriod must be used by successive generations;
+// it keeps the last entry of every url in memory.
+//
+// Urls are tracked by route (by source for GenerateToDir), as emitted by the source: a url is missing if its source
+// succeeded without emitting it. Once a source has been enumerated, the entries it no longer emits are emitted again,
+// with their lastmod set to the start of the first generation missing them so that crawlers check the pages again.
+// Retained entries go through the filters, the rules and OnEntry like the others, and the routes which are not
+// enumerated (disabled, or left out by OnlyTags) retain nothing. Retained entries are counted in Stats.RetainedURLs.
+type GracePeriod struct {
+	// Generations is the number of generations a url is retained for after its source stopped emitting it.
+	Generations int
+
+	mutex      sync.Mutex
+	generation int
+	retained   int                                  // number of entries retained by the current generation
+	sources    map[string]map[string]*retainedEntry // last entry of each url, by source and location
+	keys       map[string]string                    // source of each url
+}
+
+// retainedEntry is the last entry emitted for a url.
+type retainedEntry struct {
+	entry      *Entry
+	generation int  // last generation the url was emitted in
+	missing    bool // the lastmod of entry was set to the start of the first generation missing the url
+}
+
+// begin starts a generation.
+func (g *GracePeriod) begin() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.generation++
+	g.retained = 0
+	if g.sources == nil {
+		g.sources = make(map[string]map[string]*retainedEntry)
+		g.keys = make(map[string]string)
+	}
+}
+
+// source returns source, recording the entries it emits under key, and emitting again
+// the entries of key still in their grace period once it succeeded. Retained entries have their lastmod set to start.
+func (g *GracePeriod) source(key string, source Source, start time.Time) Source {
+	return func(emit func(*Entry) error) error {
+		err := source(func(e *Entry) error {
+			if e.FileReference != nil {
+				g.seen(key, e)
+			}
+			return emit(e)
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range g.missing(key, start) {
+			err := emit(e)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// seen records e, emitted by the source key.
+func (g *GracePeriod) seen(key string, e *Entry) {
+	entry := copyEntry(e)
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if previous, ok := g.keys[e.Location]; ok && previous != key {
+		delete(g.sources[previous], e.Location)
+	}
+	g.keys[e.Location] = key
+	urls := g.sources[key]
+	if urls == nil {
+		urls = make(map[string]*retainedEntry)
+		g.sources[key] = urls
+	}
+	urls[e.Location] = &retainedEntry{entry: entry, generation: g.generation}
+}
+
+// missing returns copies of the entries of the source key missing from the generation started at start,
+// still in their grace period, sorted by location.
+func (g *GracePeriod) missing(key string, start time.Time) []*Entry {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	var missing []*Entry
+	for _, r := range g.sources[key] {
+		if r.generation == g.generation || g.generation-r.generation > g.Generations {
+			continue
+		}
+		if !r.missing {
+			r.missing = true
+			r.entry.LastModification = &start
+		}
+		missing = append(missing, copyEntry(r.entry))
+	}
+	g.retained += len(missing)
+	sort.Sort(byLocation(missing))
+	return missing
+}
+
+// count returns the number of entries retained by the current generation.
+func (g *GracePeriod) count() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.retained
+}
+
+// end forgets the urls whose grace period is over. The generation must have succeeded.
+func (g *GracePeriod) end() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	for key, urls := range g.sources {
+		for loc, r := range urls {
+			if g.generation-r.generation >= g.Generations {
+				delete(urls, loc)
+				delete(g.keys, loc)
+			}
+		}
+		if len(urls) == 0 {
+			delete(g.sources, key)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package sitemap
+
+import (
+	"net/http"
+)
+
+// Generator generates the sitemaps. *Router implements it.
+//
+// Code triggering generations can depend on a Generator rather than on a Router, to be tested with a mock.
+type Generator interface {
+	GenerateSitemaps() ([]string, error)
+}
+
+// Notifier is notified of the end of every generation of a Router, see Options.Notifier.
+// m is the manifest of the generation, nil if it failed with err.
+type Notifier interface {
+	Notify(m *Manifest, err error)
+}
+
+// NotifierFunc is a Notifier calling itself.
+type NotifierFunc func(m *Manifest, err error)
+
+// Notify calls f(m, err).
+func (f NotifierFunc) Notify(m *Manifest, err error) {
+	f(m, err)
+}
+
+// SitemapServer serves sitemaps and triggers their generation. *Router implements it.
+type SitemapServer interface {
+	Generator
+	SitemapHandler() http.Handler
+	Manifest() (*Manifest, error)
+	Stats() Stats
+}
+
+var (
+	_ Generator     = (*Router)(nil)
+	_ SitemapServer = (*Router)(nil)
+)
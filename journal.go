@@ -0,0 +1,65 @@
+package sitemap
+
+import (
+	"encoding/json"
+)
+
+const journal_file = "journal.json"
+
+// journal records the shards completed by a generation with Options.Resume, so that an interrupted generation can be resumed.
+type journal struct {
+	Shards []*journalShard `json:"shards"`
+}
+
+// journalShard is a completed shard.
+type journalShard struct {
+	Name            string          `json:"name"`
+	Locations       []string        `json:"locations"`
+	Files           []*ManifestFile `json:"files"`
+	URLs            int             `json:"urls"`
+	DroppedURLs     int             `json:"dropped_urls,omitempty"`
+	ClampedLastMods int             `json:"clamped_lastmods,omitempty"`
+	Rejects         []Reject        `json:"rejects,omitempty"`
+}
+
+// resumable returns true if the generations with opts can be resumed: each shard must be written independently
+// of the others.
+func (o *Options) resumable() bool {
+	if !o.Resume || o.Reproducible || o.Popularity != nil || o.Probe != nil || o.Feed != nil || o.RecentWindow > 0 || o.ChangeTracker != nil || o.Delta != nil || o.GracePeriod != nil {
+		return false
+	}
+	for _, rule := range o.Rules {
+		if rule.Shard != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readJournal reads the journal of an interrupted generation from s, an empty journal if there is none.
+func readJournal(s Storage) *journal {
+	j := new(journal)
+	data, err := readFile(s, journal_file)
+	if err != nil || json.Unmarshal(data, j) != nil {
+		return new(journal)
+	}
+	return j
+}
+
+// completed returns the journal of the k-th shard, nil if it was not completed by the interrupted generation
+// (or if the shards changed since).
+func (j *journal) completed(k int, s *shard) *journalShard {
+	if k >= len(j.Shards) || j.Shards[k] == nil || j.Shards[k].Name != s.name {
+		return nil
+	}
+	return j.Shards[k]
+}
+
+// write writes j into s.
+func (j *journal) write(s Storage) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return s.WriteFile(journal_file, data)
+}
@@ -0,0 +1,200 @@
+package sitemap
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// lastModStore records the last modification times of pages, by path.
+type lastModStore struct {
+	mutex sync.RWMutex
+	times map[string]time.Time
+	etags map[string]string
+}
+
+// set records that the page at path was modified at t, unless a later modification is known.
+func (s *lastModStore) set(path string, t time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.times == nil {
+		s.times = make(map[string]time.Time)
+	}
+	if t.After(s.times[path]) {
+		s.times[path] = t
+	}
+}
+
+// setETag records the ETag of the page at path. If it differs from the previous one, the page was modified at now.
+func (s *lastModStore) setETag(path, etag string, now time.Time) {
+	s.mutex.Lock()
+	previous, known := s.etags[path]
+	if s.etags == nil {
+		s.etags = make(map[string]string)
+	}
+	s.etags[path] = etag
+	s.mutex.Unlock()
+
+	if known && previous != etag {
+		s.set(path, now)
+	}
+}
+
+// get returns the last modification time of the page at path, or nil if unknown.
+func (s *lastModStore) get(path string) *time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	t, ok := s.times[path]
+	if !ok {
+		return nil
+	}
+	lastmod := new(time.Time)
+	*lastmod = t
+	return lastmod
+}
+
+// max_learned_paths is the maximum number of pages of parameterized routes whose responses are learned from.
+const max_learned_paths = 100000
+
+// pathSet records the paths of the pages enumerated for parameterized routes, up to max_learned_paths.
+type pathSet struct {
+	mutex sync.RWMutex
+	paths map[string]bool
+}
+
+func (s *pathSet) add(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.paths == nil {
+		s.paths = make(map[string]bool)
+	}
+	if len(s.paths) < max_learned_paths {
+		s.paths[path] = true
+	}
+}
+
+func (s *pathSet) has(path string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.paths[path]
+}
+
+// isSitemapPage returns true if the request for path, matched by route, is for a page of the sitemap:
+// route is a static route registered for the sitemap, or a parameterized one whose enumerator yielded path
+// in a previous generation. Requests on arbitrary paths can't grow what is learned from them.
+func (r *Router) isSitemapPage(route *mux.Route, path string) bool {
+	r.routesMutex.RLock()
+	defer r.routesMutex.RUnlock()
+	for _, entry := range r.staticEntries {
+		if entry.Route == route {
+			return true
+		}
+	}
+	for _, entry := range r.paramEntries {
+		if entry.Route == route {
+			return r.enumerated.has(path)
+		}
+	}
+	return false
+}
+
+// headerRecorder captures the status code of a response.
+// It lets handlers flush and hijack the connection, e.g. for server-sent events and websockets.
+type headerRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (h *headerRecorder) WriteHeader(status int) {
+	if h.status == 0 {
+		h.status = status
+	}
+	h.ResponseWriter.WriteHeader(status)
+}
+
+func (h *headerRecorder) Write(b []byte) (int, error) {
+	if h.status == 0 {
+		h.status = http.StatusOK
+	}
+	return h.ResponseWriter.Write(b)
+}
+
+func (h *headerRecorder) Flush() {
+	if h.status == 0 {
+		h.status = http.StatusOK
+	}
+	if f, ok := h.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (h *headerRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("sitemap: the response writer doesn't support hijacking")
+	}
+	if h.status == 0 {
+		h.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, for http.ResponseController.
+func (h *headerRecorder) Unwrap() http.ResponseWriter {
+	return h.ResponseWriter
+}
+
+// LearnLastModified is a middleware learning the lastmod of the pages of the sitemap from the responses actually served:
+// the Last-Modified header of successful GET responses on registered routes becomes the lastmod of the page,
+// and so does the time of the response if its ETag changed since the previous one.
+// Entries with no lastmod of their own get the learned one on the next generation.
+// Only the pages of static routes, and the pages enumerated by a previous generation for parameterized routes, are learned.
+//
+// Install it on the router with:
+//
+//	r.Use(r.LearnLastModified)
+func (r *Router) LearnLastModified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &headerRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+
+		// a handler writing nothing replies 200 implicitly
+		if req.Method != "GET" || recorder.status != 0 && recorder.status != http.StatusOK {
+			return
+		}
+		path := req.URL.Path
+		route := mux.CurrentRoute(req)
+		if route == nil || !r.isSitemapPage(route, path) {
+			return
+		}
+		if lastModified, err := http.ParseTime(w.Header().Get("Last-Modified")); err == nil {
+			r.lastMods.set(path, lastModified)
+		}
+		if etag := w.Header().Get("ETag"); etag != "" {
+			r.lastMods.setETag(path, etag, r.options().now())
+		}
+	})
+}
+
+// MarkModified records that the page at path was modified at t, for applications knowing when their pages change:
+// the next generations give it t as lastmod, like LearnLastModified() (unless a later modification is known).
+// path is either the pattern of a static route, or the path of a page of a parameterized route (e.g. "/products/42").
+func (r *Router) MarkModified(path string, t time.Time) {
+	r.routesMutex.RLock()
+	for _, entry := range r.staticEntries {
+		if entry.Location == path && entry.URLBuilder != nil {
+			// the lastmod of such routes is looked up by the path of their url
+			if u, err := entry.buildURL(entry.Route); err == nil {
+				path = u.Path
+			}
+			break
+		}
+	}
+	r.routesMutex.RUnlock()
+	r.lastMods.set(path, t)
+}
@@ -0,0 +1,190 @@
+package sitemap
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// EscapeLoc converts the IRI loc to a URI, as required for sitemap locations:
+// non-ASCII host labels are converted to punycode (xn--...), and non-ASCII characters
+// and characters not allowed in URIs are percent-encoded (UTF-8) in the rest of the url.
+// Existing percent-encodings are kept, so that escaping a URI leaves it unchanged.
+//
+// Generations escape all locations this way. Use EscapeLoc when building Sitemap or Entry values directly,
+// to get the same locations. XML entity escaping is left to the encoder.
+func EscapeLoc(loc string) string {
+	scheme, host, rest := "", "", loc
+	if i := strings.Index(loc, "://"); i > 0 {
+		hostEnd := len(loc)
+		if j := strings.IndexAny(loc[i+3:], "/?#"); j >= 0 {
+			hostEnd = i + 3 + j
+		}
+		scheme, host, rest = loc[:i+3], loc[i+3:hostEnd], loc[hostEnd:]
+	}
+	escapedHost, escapedRest := escapeHost(host), escapeURIPart(rest)
+	if escapedHost == host && escapedRest == rest {
+		// nothing to escape: don't allocate
+		return loc
+	}
+	return scheme + escapedHost + escapedRest
+}
+
+// escapeHost converts the non-ASCII labels of host (which may include userinfo and port) to punycode.
+func escapeHost(host string) string {
+	if isASCII(host) {
+		return host
+	}
+	userinfo := ""
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		userinfo = escapeURIPart(host[:i+1])
+		host = host[i+1:]
+	}
+	port := ""
+	if i := strings.LastIndex(host, ":"); i >= 0 && isDigits(host[i+1:]) {
+		port = host[i:]
+		host = host[:i]
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if !isASCII(label) {
+			labels[i] = "xn--" + punycode(strings.ToLower(label))
+		}
+	}
+	return userinfo + strings.Join(labels, ".") + port
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// escapeURIPart percent-encodes the bytes of s which are not allowed in a URI.
+func escapeURIPart(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		escape := c >= utf8.RuneSelf || c <= ' ' || c == 0x7f || strings.IndexByte(`"<>\^`+"`{|}", c) >= 0 ||
+			c == '%' && !(i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]))
+		if escape && b == nil {
+			b = make([]byte, i, len(s)+16)
+			copy(b, s[:i])
+		}
+		if escape {
+			b = append(b, '%', hex[c>>4], hex[c&15])
+		} else if b != nil {
+			b = append(b, c)
+		}
+	}
+	if b == nil {
+		return s
+	}
+	return string(b)
+}
+
+// punycode parameters (RFC 3492)
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycode encodes s as specified by RFC 3492 (without the xn-- prefix).
+func punycode(s string) string {
+	runes := []rune(s)
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for handled < len(runes) {
+		m := int(^uint(0) >> 1)
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
@@ -0,0 +1,27 @@
+package sitemap
+
+import (
+	"testing"
+)
+
+func TestEscapeLoc(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com/a/b?c=d&e=f#g":           "http://example.com/a/b?c=d&e=f#g",
+		"http://example.com/produits/crème-brûlée":   "http://example.com/produits/cr%C3%A8me-br%C3%BBl%C3%A9e",
+		"http://example.com/商品/茶":                    "http://example.com/%E5%95%86%E5%93%81/%E8%8C%B6",
+		"http://example.com/a%20b/100%":              "http://example.com/a%20b/100%25",
+		"http://example.com/a b/<tag>":               "http://example.com/a%20b/%3Ctag%3E",
+		"http://bücher.example:8080/München":         "http://xn--bcher-kva.example:8080/M%C3%BCnchen",
+		"https://user@MÜNCHEN.example/":              "https://user@xn--mnchen-3ya.example/",
+		"/relative/ü":                                "/relative/%C3%BC",
+		"http://example.com/cr%C3%A8me-br%C3%BBl%C3": "http://example.com/cr%C3%A8me-br%C3%BBl%C3",
+	}
+	for iri, uri := range tests {
+		if actual := EscapeLoc(iri); actual != uri {
+			t.Errorf("%s: expecting %s but got %s", iri, uri, actual)
+		}
+		if twice := EscapeLoc(uri); twice != uri {
+			t.Errorf("%s: escaping twice gives %s", uri, twice)
+		}
+	}
+}
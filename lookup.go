@@ -0,0 +1,244 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sitemapFiles returns the names of the XML sitemaps of m generated with opts (<prefix>_*.xml), without the index, sitemap.xml
+// and the recent and delta sitemaps (copies of entries of the others).
+// When a sitemap is written both compressed and uncompressed, only the compressed file is returned.
+func (m *Manifest) sitemapFiles(opts *Options) []string {
+	return m.xmlSitemaps(opts, recent_sitemap_name, delta_sitemap_name)
+}
+
+// indexedFiles returns the names of the sitemaps of m referenced by the index, like sitemapFiles but with the recent sitemaps.
+func (m *Manifest) indexedFiles(opts *Options) []string {
+	return m.xmlSitemaps(opts, delta_sitemap_name)
+}
+
+// xmlSitemaps returns the names of the XML sitemaps of m generated with opts, except those of the buffers named excluded.
+func (m *Manifest) xmlSitemaps(opts *Options, excluded ...string) []string {
+	written := make(map[string]bool, len(m.Files))
+	for _, f := range m.Files {
+		written[f.Name] = true
+	}
+	var names []string
+files:
+	for _, f := range m.Files {
+		xml := strings.HasSuffix(f.Name, ".xml") || strings.HasSuffix(f.Name, ".xml"+gzip_extension)
+		if !strings.HasPrefix(f.Name, opts.sitemapPrefix()+"_") || f.Name == opts.indexFile() || !xml || written[f.Name+gzip_extension] {
+			continue
+		}
+		for _, name := range excluded {
+			if strings.HasPrefix(f.Name, opts.sitemapPrefix()+"_"+name+"_") {
+				continue files
+			}
+		}
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// decodeEntries decodes the entries of the sitemap name in s one at a time, decompressing it if needed,
+// and calls fn on each of them until fn returns false. Whole sitemaps are never loaded in memory.
+// It returns false if fn did.
+func decodeEntries(s Storage, name string, fn func(e *Entry) bool) (bool, error) {
+	f, err := s.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return decodeSitemap(name, f, fn)
+}
+
+// decodeSitemap decodes the entries of the sitemap name read from f, like decodeEntries.
+func decodeSitemap(name string, f io.Reader, fn func(e *Entry) bool) (bool, error) {
+	reader := f
+	if strings.HasSuffix(name, gzip_extension) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return false, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	decoder := xml.NewDecoder(bufio.NewReader(reader))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "url" {
+			e := new(Entry)
+			err = decoder.DecodeElement(e, &start)
+			if err != nil {
+				return false, err
+			}
+			if !fn(e) {
+				return false, nil
+			}
+		}
+	}
+}
+
+// walkEntries calls fn on each entry of the current generation, in order, until fn returns false.
+// The lock of the sitemaps is only held to read the manifest and each sitemap (compressed, if it is),
+// and never while fn runs, so that slow callers (e.g. the clients of ExportHandler) don't block the generations.
+// It fails if a new generation changes a sitemap during the walk.
+func (r *Router) walkEntries(fn func(file string, e *Entry) bool) error {
+	opts := r.options()
+	storage := opts.storage()
+	r.sitemapMutex.RLock()
+	m, err := readManifest(storage)
+	r.sitemapMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	hashes := make(map[string]string, len(m.Files))
+	for _, f := range m.Files {
+		hashes[f.Name] = f.SHA256
+	}
+	for _, name := range m.sitemapFiles(opts) {
+		r.sitemapMutex.RLock()
+		data, err := readFile(storage, name)
+		r.sitemapMutex.RUnlock()
+		if err != nil {
+			return err
+		}
+		if newManifestFile(name, data, 0).SHA256 != hashes[name] {
+			return fmt.Errorf("sitemap: %s changed during the walk", name)
+		}
+		more, err := decodeSitemap(name, bytes.NewReader(data), func(e *Entry) bool {
+			return fn(name, e)
+		})
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindURL looks for the full url loc in the current generation, and returns the name of the sitemap
+// containing it. It returns found false if loc is in no sitemap (e.g. filtered out or dropped),
+// and an error if the sitemaps have not been generated yet.
+//
+// The sitemaps are read from the storage, so FindURL is slow on large sites.
+func (r *Router) FindURL(loc string) (file string, found bool, err error) {
+	loc = EscapeLoc(loc)
+	err = r.walkEntries(func(name string, e *Entry) bool {
+		if e.Location == loc {
+			file, found = name, true
+		}
+		return !found
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return file, found, nil
+}
+
+// SearchQuery selects entries of the current generation, see Router.Search().
+type SearchQuery struct {
+	Prefix string         // full url prefix of the entries, ignored if empty
+	Regexp *regexp.Regexp // matched against the entry locations, ignored if nil
+	Offset int            // number of matching entries skipped
+	Limit  int            // maximum number of entries returned, DefaultSearchLimit if zero
+}
+
+// DefaultSearchLimit is the number of entries returned by a search by default.
+const DefaultSearchLimit = 100
+
+// SearchResult is a page of the entries matching a SearchQuery.
+type SearchResult struct {
+	Total   int      // number of matching entries, on all pages
+	Entries []*Entry // matching entries from the query offset
+}
+
+// Search returns the entries of the current generation matching q, in the order of the sitemaps.
+// The prefix is converted to a URI like the locations, so that IRIs can be searched.
+// Search returns an error if the sitemaps have not been generated yet.
+//
+// The sitemaps are read from the storage, so Search is slow on large sites.
+func (r *Router) Search(q *SearchQuery) (*SearchResult, error) {
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultSearchLimit
+	}
+	prefix := EscapeLoc(q.Prefix)
+	result := new(SearchResult)
+	err := r.walkEntries(func(name string, e *Entry) bool {
+		if !strings.HasPrefix(e.Location, prefix) || (q.Regexp != nil && !q.Regexp.MatchString(e.Location)) {
+			return true
+		}
+		if result.Total >= q.Offset && len(result.Entries) < limit {
+			result.Entries = append(result.Entries, e)
+		}
+		result.Total++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SearchHandler returns an http.Handler replying the result of r.Search() in JSON.
+// The query is given by the query parameters prefix, regexp, offset and limit.
+//
+// The handler is not registered anywhere: mount it on a private route.
+func (r *Router) SearchHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		params := req.URL.Query()
+		q := &SearchQuery{Prefix: params.Get("prefix")}
+		var err error
+		if expr := params.Get("regexp"); expr != "" {
+			q.Regexp, err = regexp.Compile(expr)
+			if err != nil {
+				http.Error(w, "invalid parameter regexp: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		for name, value := range map[string]*int{"offset": &q.Offset, "limit": &q.Limit} {
+			param := params.Get(name)
+			if param == "" {
+				continue
+			}
+			*value, err = strconv.Atoi(param)
+			if err == nil && *value < 0 {
+				err = errors.New("negative value")
+			}
+			if err != nil {
+				http.Error(w, "invalid parameter "+name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		result, err := r.Search(q)
+		if err != nil {
+			http.Error(w, "sitemaps unavailable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(data)
+	})
+}
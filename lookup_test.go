@@ -0,0 +1,292 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestFindURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.UpdateOptions(func(o *Options) {
+		o.Compression = &Compression{KeepUncompressed: true}
+	})
+	r.Register("/about")
+	r.Register("/café")
+
+	_, _, err = r.FindURL("http://example.com/about")
+	if err == nil {
+		t.Error("FindURL should fail before the first generation")
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		loc   string
+		found bool
+	}{
+		{"http://example.com/about", true},
+		{"http://example.com/café", true},
+		{"http://example.com/missing", false},
+	} {
+		file, found, err := r.FindURL(test.loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != test.found {
+			t.Errorf("FindURL(%q) should return found %v", test.loc, test.found)
+		}
+		if found && file != "sitemap_1.xml.gz" {
+			t.Errorf("FindURL(%q) should return sitemap_1.xml.gz but got %q", test.loc, file)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	for _, p := range []string{"/blog/a", "/blog/b", "/blog/c", "/shop/a"} {
+		r.Register(p)
+	}
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := r.Search(&SearchQuery{Prefix: "http://example.com/blog/", Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 3 || len(result.Entries) != 1 || result.Entries[0].Location != "http://example.com/blog/b" {
+		t.Errorf("Unexpected search result %d %v", result.Total, result.Entries)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/search?regexp=/a$", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r.SearchHandler().ServeHTTP(w, req)
+	result = new(SearchResult)
+	err = json.Unmarshal(w.Body.Bytes(), result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 2 || len(result.Entries) != 2 {
+		t.Errorf("Unexpected search result %d %v", result.Total, result.Entries)
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/a")
+	r.Register("/b")
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r.ExportHandler().ServeHTTP(w, req)
+	var export struct {
+		URLs []struct {
+			Loc      string  `json:"loc"`
+			Priority float64 `json:"priority"`
+		} `json:"urls"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &export)
+	if err != nil {
+		t.Fatalf("Invalid JSON %s: %v", w.Body.String(), err)
+	}
+	if len(export.URLs) != 2 || export.URLs[1].Loc != "http://example.com/b" || export.URLs[1].Priority != 0.5 {
+		t.Errorf("Unexpected export %s", w.Body.String())
+	}
+
+	sm := NewSitemap()
+	sm.Entries = []*Entry{newEntry("http://example.com/")}
+	data, err := json.Marshal(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"urls":[{"loc":"http://example.com/"}]}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+}
+
+// stalledWriter is an http.ResponseWriter whose writes block until release is closed.
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing, release chan struct{}
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestExportStalledClient(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = NewMemoryStorage()
+	for i := 0; i < 200; i++ {
+		r.Register(fmt.Sprintf("/page%d", i))
+	}
+	if _, err := r.GenerateSitemaps(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &stalledWriter{httptest.NewRecorder(), make(chan struct{}, 1), make(chan struct{})}
+	exported := make(chan struct{})
+	go func() {
+		defer close(exported)
+		r.ExportHandler().ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/export", nil))
+	}()
+	<-w.writing
+	generated := make(chan error, 1)
+	go func() {
+		_, err := r.GenerateSitemaps()
+		generated <- err
+	}()
+	select {
+	case err := <-generated:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("A stalled export client should not block the generations")
+	}
+	close(w.release)
+	<-exported
+}
+
+func TestExportError(t *testing.T) {
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = storage
+	r.Options.Events = &EventBus{}
+	r.Register("/a")
+	if _, err := r.GenerateSitemaps(); err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	r.Options.Events.Subscribe(func(e Event) { events = append(events, e) })
+	var file string
+	r.walkEntries(func(name string, e *Entry) bool {
+		file = name
+		return false
+	})
+	if err := storage.WriteFile(file, []byte("<urlset>")); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "http://example.com/export", nil)
+	w := httptest.NewRecorder()
+	r.ExportHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expecting 500 on a corrupt sitemap, got %v %s", w.Code, w.Body.String())
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expecting a ServeError, got %v", events)
+	}
+	if e, ok := events[0].(ServeError); !ok || e.Err == nil {
+		t.Errorf("Expecting a ServeError, got %v", events[0])
+	}
+}
+
+func TestDayPrecisionRoundTrip(t *testing.T) {
+	lastmod := time.Date(2020, time.January, 2, 15, 4, 5, 0, time.UTC)
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = storage
+	r.Options.LastModPrecision = DayPrecision
+	r.RegisterParamAttributes("/items/{id}", func(cb func(Attributes, ...string) error) error {
+		return cb(Attributes{LastModification: &lastmod}, "id", "1")
+	})
+	r.Register("/about")
+	r.Tag("/about", "about")
+
+	for partition := 0; partition < 2; partition++ {
+		if _, err := r.GeneratePartition(partition, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.AssembleIndex(2); err != nil {
+		t.Fatal(err)
+	}
+	var entries []*Entry
+	err := r.walkEntries(func(file string, e *Entry) bool {
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expecting 2 entries, got %v", entries)
+	}
+	for _, e := range entries {
+		if e.Location != "http://example.com/items/1" {
+			continue
+		}
+		day := time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC)
+		if e.LastModification == nil || !e.LastModification.Equal(day) || e.LastModPrecision != DayPrecision {
+			t.Errorf("Expecting the lastmod %v with day precision, got %v (%v)", day, e.LastModification, e.LastModPrecision)
+		}
+	}
+	if _, found, err := r.FindURL("http://example.com/items/1"); err != nil || !found {
+		t.Errorf("FindURL should find the entry, got %v, %v", found, err)
+	}
+}
+
+func TestParseLastMod(t *testing.T) {
+	for s, precision := range map[string]DatePrecision{
+		"2020":                          DayPrecision,
+		"2020-01":                       DayPrecision,
+		"2020-01-02":                    DayPrecision,
+		"2020-01-02T15:04+01:00":        SecondPrecision,
+		"2020-01-02T15:04:05Z":          SecondPrecision,
+		"2020-01-02T15:04:05.5-05:00":   NanosecondPrecision,
+		" 2020-01-02T15:04:05.123456Z ": NanosecondPrecision,
+	} {
+		_, p, err := parseLastMod(s)
+		if err != nil || p != precision {
+			t.Errorf("parseLastMod(%q) returns precision %v, %v instead of %v", s, p, err, precision)
+		}
+	}
+	if _, _, err := parseLastMod("yesterday"); err == nil {
+		t.Error("parseLastMod should fail on an invalid date")
+	}
+}
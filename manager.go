@@ -0,0 +1,227 @@
+package sitemap
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Manager serves the sitemaps of many sites behind a single http.Handler, dispatching requests by Host.
+//
+// Each site is a Router, with its own domain, options, registered routes and cache directory:
+//
+//	m := NewManager()
+//	for _, customer := range customers {
+//	  r := NewRouter(mux.NewRouter(), "https://"+customer.Host, "cache/"+customer.Id)
+//	  r.RegisterParam(...)
+//	  r.HandleSitemaps()
+//	  m.AddSite(customer.Host, r)
+//	}
+//	http.Handle("/", m)
+//
+// Sites are independent: generating the sitemaps of one site never blocks serving or generating another one.
+type Manager struct {
+	// Storage, if non-nil, is shared by all sites: AddSite makes each site store its files
+	// under a prefix named after its host, isolated from the other sites.
+	Storage Storage
+	// CachePath, if set and Storage is not, is a directory shared by all sites: AddSite makes each site
+	// cache its files in a subdirectory named after its host.
+	CachePath string
+	// Workers is the maximum number of sites regenerated concurrently by Run() (1 if zero).
+	Workers int
+	// PollInterval is how often Run() looks for sites to regenerate (DefaultPollInterval if zero).
+	PollInterval time.Duration
+	// Clock is the clock of the polls of Run() (SystemClock if nil). AfterFunc is used if it is a Timer.
+	Clock Clock
+
+	mutex sync.RWMutex
+	sites map[string]*Router
+}
+
+// NewManager creates a Manager without any site.
+func NewManager() *Manager {
+	return &Manager{
+		sites: make(map[string]*Router),
+	}
+}
+
+// normalizeHost returns host in lower case, without port.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// AddSite serves r for all requests on host (the port is ignored), replacing any previous site for that host.
+// Call r.HandleSitemaps() so that r serves its sitemaps.
+//
+// If m.Storage is set, the storage of r is replaced by the namespace of host in m.Storage.
+// Otherwise, if m.CachePath is set, the cache directory of r is replaced by the subdirectory of host in m.CachePath.
+// Each site keeps its own Options.ServerPath.
+func (m *Manager) AddSite(host string, r *Router) {
+	host = normalizeHost(host)
+	if m.Storage != nil {
+		r.UpdateOptions(func(o *Options) {
+			o.Storage = PrefixStorage(m.Storage, host)
+		})
+	} else if m.CachePath != "" {
+		r.UpdateOptions(func(o *Options) {
+			o.CachePath = filepath.Join(m.CachePath, host)
+		})
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sites[host] = r
+}
+
+// RemoveSite stops serving host. Cached files are left untouched.
+func (m *Manager) RemoveSite(host string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.sites, normalizeHost(host))
+}
+
+// Site returns the Router serving host, or nil if there is none.
+func (m *Manager) Site(host string) *Router {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.sites[normalizeHost(host)]
+}
+
+// Hosts returns the sorted list of hosts served.
+func (m *Manager) Hosts() []string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	hosts := make([]string, 0, len(m.sites))
+	for host := range m.sites {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Stats returns the statistics of all sites, by host.
+func (m *Manager) Stats() map[string]Stats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	stats := make(map[string]Stats, len(m.sites))
+	for host, r := range m.sites {
+		stats[host] = r.Stats()
+	}
+	return stats
+}
+
+// DefaultPollInterval is the default Manager.PollInterval.
+const DefaultPollInterval = time.Minute
+
+// Run regenerates the sitemaps of every site whose Options.RefreshInterval has elapsed since its last generation
+// (or which was never generated), until ctx is done. It then waits for the generations underway and returns ctx.Err().
+//
+// The scheduling is fair: sites are regenerated in the order they became due, by at most m.Workers at a time.
+// A huge site occupies a single worker, and goes back to the end of the queue once regenerated,
+// so it can't starve the other sites. Sites without RefreshInterval are never regenerated by Run.
+func (m *Manager) Run(ctx context.Context) error {
+	workers := m.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	poll := m.PollInterval
+	if poll <= 0 {
+		poll = DefaultPollInterval
+	}
+	clock := m.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	tick := make(chan struct{}, 1)
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+	var runningMutex sync.Mutex
+	running := make(map[*Router]bool)
+	isRunning := func(r *Router) bool {
+		runningMutex.Lock()
+		defer runningMutex.Unlock()
+		return running[r]
+	}
+
+	for {
+	schedule:
+		for _, r := range m.dueSites(isRunning) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				break schedule // all workers are busy
+			}
+			runningMutex.Lock()
+			running[r] = true
+			runningMutex.Unlock()
+			wg.Add(1)
+			go func(r *Router) {
+				defer wg.Done()
+				r.GenerateSitemaps() // errors are reported in r.Stats()
+				runningMutex.Lock()
+				delete(running, r)
+				runningMutex.Unlock()
+				<-slots
+			}(r)
+		}
+
+		afterFunc(clock, poll, func() {
+			select {
+			case tick <- struct{}{}:
+			default: // the previous tick is still pending
+			}
+		})
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case <-tick:
+		}
+	}
+}
+
+// dueSites returns the sites to regenerate, which are not running, ordered by the time they became due.
+func (m *Manager) dueSites(running func(*Router) bool) []*Router {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var due []*Router
+	dueTimes := make(map[*Router]time.Time)
+	for _, r := range m.sites {
+		opts := r.options()
+		if opts.RefreshInterval <= 0 || running(r) {
+			continue
+		}
+		last := r.Stats().LastAttempt
+		if !last.IsZero() && opts.now().Sub(last) < opts.RefreshInterval {
+			continue
+		}
+		due = append(due, r)
+		if !last.IsZero() {
+			dueTimes[r] = last.Add(opts.RefreshInterval)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return dueTimes[due[i]].Before(dueTimes[due[j]])
+	})
+	return due
+}
+
+// ServeHTTP dispatches the request to the site of its host, or replies 404 if the host is unknown.
+func (m *Manager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	site := m.Site(req.Host)
+	if site == nil {
+		http.NotFound(w, req)
+		return
+	}
+	site.ServeHTTP(w, req)
+}
@@ -0,0 +1,251 @@
+package sitemap
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager()
+	m.Storage = DirStorage(dir)
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		r := NewRouter(mux.NewRouter(), "http://"+host, "unused")
+		r.Register("/" + host)
+		r.HandleSitemaps()
+		m.AddSite(host, r)
+	}
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		index := new(SitemapIndex)
+		mustServeXML(m, "http://"+host+":8080/sitemapindex.xml", index, t)
+		if len(index.SitemapRefs) != 1 {
+			t.Fatalf("%s: expecting exactly one sitemap", host)
+		}
+
+		sm := new(Sitemap)
+		mustServeXML(m, index.SitemapRefs[0].Location, sm, t)
+		if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://"+host+"/"+host {
+			t.Errorf("%s: unexpected entries %v", host, sm.Entries)
+		}
+
+		_, err = os.Stat(filepath.Join(dir, host, "sitemapindex.xml"))
+		if err != nil {
+			t.Errorf("%s: files should be stored in its namespace: %v", host, err)
+		}
+	}
+
+	for host, stats := range m.Stats() {
+		if stats.Generations != 1 || stats.URLs != 1 {
+			t.Errorf("%s: unexpected stats %+v", host, stats)
+		}
+	}
+
+	req, err := http.NewRequest("GET", "http://c.example.com/sitemapindex.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expecting 404 for unknown host but got %d", w.Code)
+	}
+}
+
+func TestManagerCachePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager()
+	m.CachePath = dir
+	for host, serverPath := range map[string]string{"a.example.com": "/", "b.example.com": "/sitemaps/"} {
+		r := NewRouter(mux.NewRouter(), "http://"+host, "unused")
+		r.Options.ServerPath = serverPath
+		r.Register("/" + host)
+		r.HandleSitemaps()
+		m.AddSite(host, r)
+
+		index := new(SitemapIndex)
+		mustServeXML(m, "http://"+host+serverPath+"sitemapindex.xml", index, t)
+		if len(index.SitemapRefs) != 1 || index.SitemapRefs[0].Location != "http://"+host+serverPath+"sitemap_1.xml" {
+			t.Errorf("%s: unexpected sitemaps %v", host, index.SitemapRefs)
+		}
+		_, err = os.Stat(filepath.Join(dir, host, "sitemapindex.xml"))
+		if err != nil {
+			t.Errorf("%s: files should be cached in its subdirectory: %v", host, err)
+		}
+	}
+}
+
+func mustServeXML(h http.Handler, addr string, v interface{}, t *testing.T) {
+	req, err := http.NewRequest("GET", addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s replies %d", addr, w.Code)
+	}
+
+	err = xml.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewManager()
+	m.Storage = DirStorage(dir)
+	m.Workers = 2
+	m.PollInterval = time.Millisecond
+	for _, host := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		r := NewRouter(mux.NewRouter(), "http://"+host, "unused")
+		r.Options.RefreshInterval = time.Hour
+		r.Options.MaxURLs = 1
+		r.Register("/one")
+		r.Register("/two")
+		m.AddSite(host, r)
+	}
+	r := NewRouter(mux.NewRouter(), "http://static.example.com", "unused")
+	m.AddSite("static.example.com", r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = m.Run(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	for host, stats := range m.Stats() {
+		expected := 1
+		if host == "static.example.com" {
+			expected = 0
+		}
+		if stats.Generations != expected {
+			t.Errorf("%s: expecting %d generations but got %d", host, expected, stats.Generations)
+		}
+		if expected > 0 && (stats.URLs != 1 || stats.DroppedURLs != 1) {
+			t.Errorf("%s: expecting 1 url and 1 dropped but got %d and %d", host, stats.URLs, stats.DroppedURLs)
+		}
+	}
+}
+
+// syncClock is a Clock safe for concurrent use, whose time is advanced by tests.
+type syncClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (c *syncClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *syncClock) advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// chanTimer is a Timer passing the functions of AfterFunc to the tests, which call them when they are due.
+type chanTimer struct {
+	Clock
+	funcs chan func()
+}
+
+func (c *chanTimer) AfterFunc(d time.Duration, f func()) {
+	c.funcs <- f
+}
+
+func TestManagerRunClock(t *testing.T) {
+	clock := &syncClock{now: time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)}
+	timer := &chanTimer{Clock: clock, funcs: make(chan func())}
+	m := NewManager()
+	m.Storage = NewMemoryStorage()
+	m.Clock = timer
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Options.Clock = clock
+	r.Options.RefreshInterval = time.Hour
+	r.Register("/")
+	m.AddSite("example.com", r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+	waitGenerations := func(expected int) {
+		for deadline := time.Now().Add(time.Second); r.Stats().Generations != expected; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expecting %d generations but got %d", expected, r.Stats().Generations)
+			}
+		}
+	}
+
+	tick := <-timer.funcs
+	waitGenerations(1)
+	tick()
+	tick = <-timer.funcs
+	if generations := r.Stats().Generations; generations != 1 {
+		t.Errorf("Expecting no generation before the refresh interval, got %d", generations)
+	}
+	clock.advance(time.Hour)
+	tick()
+	<-timer.funcs
+	waitGenerations(2)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expecting %v but got %v", context.Canceled, err)
+	}
+}
+
+func TestStrictHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "https://example.com", dir)
+	r.Options.StrictHost = true
+	r.Register("/about")
+	r.HandleSitemaps()
+
+	mustServeXML(r, "http://example.com/sitemapindex.xml", new(SitemapIndex), t)
+	req, err := http.NewRequest("GET", "http://alias.example.org/sitemapindex.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expecting 404 on another host but got %d", w.Code)
+	}
+}
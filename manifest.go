@@ -0,0 +1,128 @@
+package sitemap
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const manifest_file = "manifest.json"
+
+// Manifest describes the files of a generation, as written to manifest.json next to the sitemaps.
+type Manifest struct {
+	Generated time.Time `json:"generated"`
+	URLs      int       `json:"urls"` // total number of entries in the sitemaps
+	// DroppedURLs is the number of entries left out because of Options.MaxURLs.
+	DroppedURLs int             `json:"dropped_urls,omitempty"`
+	Files       []*ManifestFile `json:"files"`
+	// ClampedLastMods is the number of entries whose lastmod was in the future, see Options.ClampLastMod.
+	ClampedLastMods int `json:"clamped_lastmods,omitempty"`
+	// RejectedURLs is the number of invalid entries left out, listed in rejects.json (see Options.RejectInvalid).
+	RejectedURLs int `json:"rejected_urls,omitempty"`
+	// RetainedURLs is the number of entries no longer emitted by their source but still in their grace period,
+	// see Options.GracePeriod.
+	RetainedURLs int `json:"retained_urls,omitempty"`
+	// Shards describes the sitemaps of each shard (see Router.Tag), in the order of the files.
+	Shards []ShardStats `json:"shards,omitempty"`
+}
+
+// ShardStats describes the sitemaps of a shard, e.g. to tune the sharding or spot unexpectedly long urls.
+type ShardStats struct {
+	Name     string `json:"name"`     // tags of the shard, empty for the routes without tags
+	Sitemaps int    `json:"sitemaps"` // number of sitemaps referenced by the index
+	URLs     int    `json:"urls"`
+	Bytes    int64  `json:"bytes"` // total size of the files of the shard (compressed or not, text sitemaps included)
+}
+
+// newShardStats describes the sitemaps written by the buffer of a shard.
+func newShardStats(b *Buffer) ShardStats {
+	s := ShardStats{Name: b.Name, Sitemaps: len(b.Locations)}
+	referenced := make(map[string]bool, len(b.Locations))
+	for _, loc := range b.Locations {
+		referenced[loc] = true
+	}
+	for _, f := range b.Files {
+		s.Bytes += f.Size
+		if referenced[f.Name] {
+			s.URLs += f.URLs
+		}
+	}
+	return s
+}
+
+// ManifestFile describes a generated file.
+type ManifestFile struct {
+	Name   string `json:"name"`   // path relative to the cache directory
+	Size   int64  `json:"size"`   // in bytes
+	URLs   int    `json:"urls"`   // number of entries (number of sitemaps for an index)
+	SHA256 string `json:"sha256"` // hex-encoded hash of the content
+}
+
+// newManifestFile describes the file name with the given content and number of urls.
+func newManifestFile(name string, data []byte, urls int) *ManifestFile {
+	sum := sha256.Sum256(data)
+	return &ManifestFile{
+		Name:   name,
+		Size:   int64(len(data)),
+		URLs:   urls,
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// fileNames returns the names of all files in the manifest, followed by the name of the manifest itself.
+func (m *Manifest) fileNames() []string {
+	names := make([]string, 0, len(m.Files)+1)
+	for _, f := range m.Files {
+		names = append(names, f.Name)
+	}
+	return append(names, manifest_file)
+}
+
+// writeManifest writes m in JSON format into s, followed by its signature if key is set (see Options.SigningKey).
+func writeManifest(m *Manifest, s Storage, key ed25519.PrivateKey) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = s.WriteFile(manifest_file, data)
+	if err != nil || key == nil {
+		return err
+	}
+	return writeSignature(data, key, s)
+}
+
+// Manifest returns the manifest of the current generation, read from the storage.
+// It returns an error if the sitemaps have not been generated yet.
+func (r *Router) Manifest() (*Manifest, error) {
+	r.sitemapMutex.RLock()
+	defer r.sitemapMutex.RUnlock()
+	return readManifest(r.options().storage())
+}
+
+// readManifest reads the manifest from s.
+func readManifest(s Storage) (*Manifest, error) {
+	data, err := readFile(s, manifest_file)
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	err = json.Unmarshal(data, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// HandleManifest registers a route serving the manifest at r.Options.ServerPath + "manifest.json",
+// and its signature at "manifest.json.sig" (see Options.SigningKey).
+// Like the sitemaps, the manifest is generated on the first request. The http handler is returned.
+func (r *Router) HandleManifest() http.Handler {
+	handler := r.SitemapHandler()
+	r.handleFiles(handler, func(*Options) []string {
+		return []string{manifest_file, signature_file}
+	})
+	return handler
+}
@@ -0,0 +1,87 @@
+package sitemap
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"sync"
+	"time"
+)
+
+// memoryStorage is a Storage in memory.
+type memoryStorage struct {
+	mutex sync.RWMutex
+	files map[string]*memoryFileInfo
+	clock Clock // source of the modification times
+}
+
+// NewMemoryStorage returns an empty Storage keeping the files in memory, e.g. for tests or small sites without disk.
+func NewMemoryStorage() Storage {
+	return NewMemoryStorageWithClock(SystemClock)
+}
+
+// NewMemoryStorageWithClock is like NewMemoryStorage, but the files are modified at the times of c,
+// e.g. the Options.Clock of the router.
+func NewMemoryStorageWithClock(c Clock) Storage {
+	return &memoryStorage{
+		files: make(map[string]*memoryFileInfo),
+		clock: c,
+	}
+}
+
+func (m *memoryStorage) Open(name string) (http.File, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	info, ok := m.files[pathpkg.Clean("/"+name)]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return &memoryFile{
+		Reader: bytes.NewReader(info.data),
+		info:   info,
+	}, nil
+}
+
+func (m *memoryStorage) WriteFile(name string, data []byte) error {
+	name = pathpkg.Clean("/" + name)
+	info := &memoryFileInfo{
+		name:    pathpkg.Base(name),
+		data:    append([]byte(nil), data...),
+		modTime: m.clock.Now(),
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files[name] = info
+	return nil
+}
+
+// memoryFileInfo describes a file of a memoryStorage. Its data is never modified.
+type memoryFileInfo struct {
+	name    string
+	data    []byte
+	modTime time.Time
+}
+
+func (i *memoryFileInfo) Name() string       { return i.name }
+func (i *memoryFileInfo) Size() int64        { return int64(len(i.data)) }
+func (i *memoryFileInfo) Mode() os.FileMode  { return 0444 }
+func (i *memoryFileInfo) ModTime() time.Time { return i.modTime }
+func (i *memoryFileInfo) IsDir() bool        { return false }
+func (i *memoryFileInfo) Sys() interface{}   { return nil }
+
+// memoryFile is an opened file of a memoryStorage.
+type memoryFile struct {
+	*bytes.Reader
+	info *memoryFileInfo
+}
+
+func (f *memoryFile) Close() error { return nil }
+
+func (f *memoryFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *memoryFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
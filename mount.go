@@ -0,0 +1,43 @@
+package sitemap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mount adds the routes registered in other to the sitemap of r under the path prefix (e.g. "/blog"),
+// so that sub-applications served under a prefix can each register their own routes:
+// the urls of their entries get the prefix, and their patterns in r (e.g. for Tag()) start with it.
+// The settings of the routes (priority, tags, metadata...) are kept, and the routes registered in other afterwards are not added.
+//
+// Mount only changes the sitemap: other must be served under prefix separately,
+// e.g. with r.PathPrefix(prefix).Handler(http.StripPrefix(prefix, other)).
+// Patterns already registered in r are not added again, see Conflicts().
+func (r *Router) Mount(prefix string, other *Router) error {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !strings.HasPrefix(prefix, "/") {
+		return fmt.Errorf("sitemap: invalid mount prefix %q, it must start with a slash", prefix)
+	}
+	static, params := other.registered()
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+	for _, entry := range static {
+		if r.conflict(prefix+entry.Location, "Mount") {
+			continue
+		}
+		mounted := *entry
+		mounted.Location = prefix + entry.Location
+		mounted.PathPrefix = prefix + entry.PathPrefix
+		r.staticEntries = append(r.staticEntries, &mounted)
+	}
+	for _, entry := range params {
+		if r.conflict(prefix+entry.Pattern, "Mount") {
+			continue
+		}
+		mounted := *entry
+		mounted.Pattern = prefix + entry.Pattern
+		mounted.PathPrefix = prefix + entry.PathPrefix
+		r.paramEntries = append(r.paramEntries, &mounted)
+	}
+	return nil
+}
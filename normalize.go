@@ -0,0 +1,103 @@
+package sitemap
+
+import (
+	"strings"
+)
+
+// TrailingSlash is a policy for the trailing slash of the paths of locations, see Options.TrailingSlash.
+type TrailingSlash int
+
+const (
+	KeepTrailingSlash  TrailingSlash = iota // locations are left as enumerated
+	AddTrailingSlash                        // "/about" becomes "/about/"
+	StripTrailingSlash                      // "/about/" becomes "/about"; the root path "/" is kept
+)
+
+// splitLoc splits the full url loc into its scheme and host, its path, and its query and fragment.
+func splitLoc(loc string) (origin, path, rest string) {
+	start := 0
+	if i := strings.Index(loc, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	end := strings.IndexAny(loc[start:], "?#")
+	if end < 0 {
+		end = len(loc)
+	} else {
+		end += start
+	}
+	slash := strings.IndexByte(loc[start:end], '/')
+	if slash < 0 {
+		return loc[:end], "", loc[end:]
+	}
+	slash += start
+	return loc[:slash], loc[slash:end], loc[end:]
+}
+
+// apply returns loc with the trailing slash of its path added or stripped according to t.
+func (t TrailingSlash) apply(loc string) string {
+	if t == KeepTrailingSlash {
+		return loc
+	}
+	origin, path, rest := splitLoc(loc)
+	switch t {
+	case AddTrailingSlash:
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+	case StripTrailingSlash:
+		if len(path) > 1 {
+			path = strings.TrimRight(path, "/")
+			if path == "" {
+				path = "/"
+			}
+		}
+	}
+	return origin + path + rest
+}
+
+// lowercaseHost returns loc with its scheme and host in lowercase.
+func lowercaseHost(loc string) string {
+	origin, path, rest := splitLoc(loc)
+	return strings.ToLower(origin) + path + rest
+}
+
+// lowercasePath returns loc with its path in lowercase, except percent-encoded octets.
+func lowercasePath(loc string) string {
+	origin, path, rest := splitLoc(loc)
+	var lower []byte
+	for i := 0; i < len(path); i++ {
+		if path[i] == '%' && i+2 < len(path) && isHex(path[i+1]) && isHex(path[i+2]) {
+			lower = append(lower, path[i:i+3]...)
+			i += 2
+			continue
+		}
+		// find the next escape, to lowercase the text before it at once
+		end := strings.IndexByte(path[i+1:], '%')
+		if end < 0 {
+			end = len(path)
+		} else {
+			end += i + 1
+		}
+		lower = append(lower, strings.ToLower(path[i:end])...)
+		i = end - 1
+	}
+	return origin + string(lower) + rest
+}
+
+// lowercasePaths returns a Source yielding the entries of source with their paths in lowercase.
+func lowercasePaths(source Source) Source {
+	return func(emit func(*Entry) error) error {
+		return source(func(e *Entry) error {
+			e.Location = lowercasePath(e.Location)
+			return emit(e)
+		})
+	}
+}
+
+// KeepPathCase exempts the route registered with pattern from Options.LowercasePaths,
+// e.g. for routes with case-sensitive identifiers in their paths.
+func (r *Router) KeepPathCase(pattern string) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.KeepPathCase = true
+	})
+}
@@ -0,0 +1,53 @@
+package sitemap
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	for _, test := range []struct {
+		policy        TrailingSlash
+		loc, expected string
+	}{
+		{KeepTrailingSlash, "http://example.com/about/", "http://example.com/about/"},
+		{AddTrailingSlash, "http://example.com/about", "http://example.com/about/"},
+		{AddTrailingSlash, "http://example.com/about/", "http://example.com/about/"},
+		{AddTrailingSlash, "http://example.com", "http://example.com/"},
+		{AddTrailingSlash, "http://example.com/search?q=a/b#top", "http://example.com/search/?q=a/b#top"},
+		{StripTrailingSlash, "http://example.com/about/", "http://example.com/about"},
+		{StripTrailingSlash, "http://example.com/about//?q=1", "http://example.com/about?q=1"},
+		{StripTrailingSlash, "http://example.com/", "http://example.com/"},
+		{StripTrailingSlash, "http://example.com?q=1", "http://example.com?q=1"},
+	} {
+		if actual := test.policy.apply(test.loc); actual != test.expected {
+			t.Errorf("Expecting %s but got %s (policy %d on %s)", test.expected, actual, test.policy, test.loc)
+		}
+	}
+}
+
+func TestLowercase(t *testing.T) {
+	if actual := lowercaseHost("HTTP://Example.COM/About?Q=A"); actual != "http://example.com/About?Q=A" {
+		t.Errorf("Unexpected host lowercasing %s", actual)
+	}
+	if actual := lowercasePath("http://Example.com/About/Caf%C3%A9/ÉTÉ%zz?Q=A"); actual != "http://Example.com/about/caf%C3%A9/été%zz?Q=A" {
+		t.Errorf("Unexpected path lowercasing %s", actual)
+	}
+
+	r := NewRouter(mux.NewRouter(), "http://Example.com", "unused")
+	r.Register("/About")
+	r.Register("/Files/ABC")
+	r.UpdateOptions(func(o *Options) {
+		o.LowercaseHosts = true
+		o.LowercasePaths = true
+	})
+	err := r.KeepPathCase("/Files/ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := r.Inspect(1)
+	if reports[0].Entries[0].Location != "http://Example.com/about" || reports[1].Entries[0].Location != "http://Example.com/Files/ABC" {
+		t.Errorf("Unexpected locations %s and %s", reports[0].Entries[0].Location, reports[1].Entries[0].Location)
+	}
+}
@@ -0,0 +1,109 @@
+package sitemap
+
+import (
+	"sync"
+	"time"
+)
+
+// ThrottleNotifier returns a Notifier notifying n of successful generations at most once per interval,
+// e.g. to ping search engines without getting rate-limited when generations are frequent (deploys...).
+// The first generation is notified right away; the following ones within interval are coalesced,
+// and only the last of them is notified, once interval has elapsed. Failed generations are notified right away.
+//
+// Used as Options.Notifier, the time is given by Options.Clock (see Timer); it is SystemClock when Notify is called directly.
+func ThrottleNotifier(n Notifier, interval time.Duration) Notifier {
+	return &throttledNotifier{notifier: n, interval: interval}
+}
+
+type throttledNotifier struct {
+	mutex    sync.Mutex
+	notifier Notifier
+	interval time.Duration
+	last     time.Time // time of the last notification of a successful generation
+	pending  *Manifest // last generation not notified yet, if any
+}
+
+func (t *throttledNotifier) Notify(m *Manifest, err error) {
+	t.notifyAt(SystemClock, m, err)
+}
+
+func (t *throttledNotifier) notifyAt(clock Clock, m *Manifest, err error) {
+	if err != nil {
+		t.notifier.Notify(m, err)
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if wait := t.last.Add(t.interval).Sub(clock.Now()); wait > 0 {
+		if t.pending == nil {
+			afterFunc(clock, wait, func() { t.flush(clock) })
+		}
+		t.pending = m
+		return
+	}
+	t.last = clock.Now()
+	t.notifier.Notify(m, nil)
+}
+
+// flush notifies the pending generation.
+func (t *throttledNotifier) flush(clock Clock) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.pending == nil {
+		return
+	}
+	m := t.pending
+	t.last, t.pending = clock.Now(), nil
+	t.notifier.Notify(m, nil)
+}
+
+// clockedNotifier is a Notifier telling the time by the Clock of the generations, see notificationQueue.
+type clockedNotifier interface {
+	notifyAt(clock Clock, m *Manifest, err error)
+}
+
+// notificationQueue hands the generations over to Options.Notifier once the lock of the sitemaps is released,
+// so that a slow notifier (e.g. pinging search engines) doesn't block serving the sitemaps.
+type notificationQueue struct {
+	mutex    sync.Mutex // guards pending
+	pending  []notification
+	notifier sync.Mutex // held while notifying, so that the generations are notified in order
+}
+
+type notification struct {
+	notifier Notifier
+	clock    Clock
+	manifest *Manifest
+	err      error
+}
+
+// add queues the notification of a generation, if opts has a Notifier. It may be called with the lock of the sitemaps held.
+func (q *notificationQueue) add(opts *Options, m *Manifest, err error) {
+	if opts.Notifier == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	clock := opts.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	q.pending = append(q.pending, notification{opts.Notifier, clock, m, err})
+}
+
+// flush notifies the queued generations. It must be called without the lock of the sitemaps.
+func (q *notificationQueue) flush() {
+	q.notifier.Lock()
+	defer q.notifier.Unlock()
+	q.mutex.Lock()
+	pending := q.pending
+	q.pending = nil
+	q.mutex.Unlock()
+	for _, n := range pending {
+		if c, ok := n.notifier.(clockedNotifier); ok {
+			c.notifyAt(n.clock, n.manifest, n.err)
+		} else {
+			n.notifier.Notify(n.manifest, n.err)
+		}
+	}
+}
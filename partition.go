@@ -0,0 +1,153 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+)
+
+// GeneratePartition generates a part of the sitemaps of r, for generations split across processes sharing the same storage.
+// The shards of r (see Tag()) are partitioned among the processes by rank: this one generates the shards of rank
+// partition modulo partitions (partition from 0 to partitions-1). Every process must register the same routes.
+//
+// The index of the partition and its manifest are written into partitions/<partition>/: once every partition is generated,
+// a single process calls AssembleIndex() to write the index of all the sitemaps.
+// Feed, SingleSitemap, RecentWindow and Delta are not supported (ignored), and Options.MaxURLs applies to each partition.
+//
+// All files created is returned.
+func (r *Router) GeneratePartition(partition, partitions int) ([]string, error) {
+	if partition < 0 || partition >= partitions {
+		return nil, fmt.Errorf("sitemap: invalid partition %d of %d", partition, partitions)
+	}
+	r.sitemapMutex.Lock()
+	defer r.sitemapMutex.Unlock()
+	opts := *r.options()
+	opts.Storage = partitionStorage{Storage: opts.storage(), opts: &opts, dir: partitionDir(partition)}
+	opts.Feed, opts.SingleSitemap, opts.RecentWindow, opts.Delta = nil, false, 0, nil
+
+	sources, routes := r.sources(&opts, true)
+	var shards []*shard
+	for i, s := range shardSources(sources, routes, &opts) {
+		if i%partitions == partition {
+			shards = append(shards, s)
+		}
+	}
+	manifest, err := generate(shards, &opts)
+	if err != nil {
+		return nil, err
+	}
+	names := manifest.fileNames()
+	names[len(names)-2] = partitionDir(partition) + opts.indexFile()
+	names[len(names)-1] = partitionDir(partition) + manifest_file
+	return names, nil
+}
+
+// AssembleIndex writes the index and the manifest of the sitemaps generated by GeneratePartition() for every partition,
+// and records the generation in r.Stats(). It fails if a partition is missing.
+//
+// All files of the generation is returned.
+func (r *Router) AssembleIndex(partitions int) ([]string, error) {
+	defer r.notifications.flush()
+	r.sitemapMutex.Lock()
+	defer r.sitemapMutex.Unlock()
+	opts := r.options()
+	start := opts.now()
+	manifest, err := assembleIndex(opts, partitions)
+	r.fetches.reset()
+	r.stats.record(manifest, start, opts.now().Sub(start), err)
+	r.notifications.add(opts, manifest, err)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.fileNames(), nil
+}
+
+// assembleIndex does the work of AssembleIndex.
+func assembleIndex(opts *Options, partitions int) (*Manifest, error) {
+	storage := opts.storage()
+	manifest := new(Manifest)
+	var locations []string
+	for partition := 0; partition < partitions; partition++ {
+		s := partitionStorage{Storage: storage, opts: opts, dir: partitionDir(partition)}
+		m, err := readManifest(s)
+		if err != nil {
+			return nil, fmt.Errorf("sitemap: partition %d: %v", partition, err)
+		}
+		data, err := readFile(s, opts.indexFile())
+		if err != nil {
+			return nil, fmt.Errorf("sitemap: partition %d: %v", partition, err)
+		}
+		index := new(SitemapIndex)
+		err = xml.Unmarshal(data, index)
+		if err != nil {
+			return nil, fmt.Errorf("sitemap: partition %d: %v", partition, err)
+		}
+		for _, ref := range index.SitemapRefs {
+			locations = append(locations, ref.Location)
+		}
+		manifest.URLs += m.URLs
+		manifest.DroppedURLs += m.DroppedURLs
+		manifest.ClampedLastMods += m.ClampedLastMods
+		manifest.RejectedURLs += m.RejectedURLs
+		manifest.RetainedURLs += m.RetainedURLs
+		manifest.Files = append(manifest.Files, m.Files[:len(m.Files)-1]...) // without the index of the partition
+		manifest.Shards = append(manifest.Shards, m.Shards...)
+		if m.Generated.After(manifest.Generated) {
+			manifest.Generated = m.Generated
+		}
+	}
+	if !opts.Reproducible {
+		manifest.Generated = opts.now()
+	}
+
+	index := NewSitemapIndex(locations)
+	if !manifest.Generated.IsZero() {
+		for _, ref := range index.SitemapRefs {
+			ref.LastModification = &manifest.Generated
+			ref.LastModPrecision = opts.LastModPrecision
+		}
+	}
+	data := new(bytes.Buffer)
+	err := encodeSitemapIndexHeader(data, index, xmlHeader(opts.XMLHeader, opts.OmitXMLHeader))
+	if err != nil {
+		return nil, err
+	}
+	err = storage.WriteFile(opts.indexFile(), data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files = append(manifest.Files, newManifestFile(opts.indexFile(), data.Bytes(), len(locations)))
+	err = writeManifest(manifest, storage, opts.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// partitionDir returns the directory of the index and of the manifest of a partition.
+func partitionDir(partition int) string {
+	return fmt.Sprintf("partitions/%d/", partition)
+}
+
+// partitionStorage is a Storage whose index, manifest (and its signature), journal and rejects are in the directory dir.
+type partitionStorage struct {
+	Storage
+	opts *Options
+	dir  string
+}
+
+func (s partitionStorage) name(name string) string {
+	if name == s.opts.indexFile() || name == manifest_file || name == journal_file || name == rejects_file || name == signature_file {
+		return s.dir + name
+	}
+	return name
+}
+
+func (s partitionStorage) Open(name string) (http.File, error) {
+	return s.Storage.Open(s.name(name))
+}
+
+func (s partitionStorage) WriteFile(name string, data []byte) error {
+	return s.Storage.WriteFile(s.name(name), data)
+}
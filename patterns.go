@@ -0,0 +1,104 @@
+package sitemap
+
+import (
+	"fmt"
+	"net/url"
+	pathpkg "path"
+	"regexp"
+	"strings"
+)
+
+// regexp_prefix marks the patterns of Options.Include and Options.Exclude which are regular expressions.
+const regexp_prefix = "regexp:"
+
+// compilePattern compiles a pattern of Options.Include or Options.Exclude.
+//
+// Glob patterns match the whole path: "*" matches any sequence of characters except "/",
+// "**" matches any sequence of characters and "?" matches any character except "/".
+// Glob patterns without "/" match the last segment of the path (e.g. "*.json").
+func compilePattern(pattern string) (*regexp.Regexp, bool, error) {
+	if strings.HasPrefix(pattern, regexp_prefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, regexp_prefix))
+		return re, false, err
+	}
+	expr := new(strings.Builder)
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	return re, !strings.Contains(pattern, "/"), err
+}
+
+// pathMatcher matches the paths of locations against patterns.
+type pathMatcher struct {
+	patterns []*regexp.Regexp
+	base     []bool // whether each pattern matches the last segment of the path only
+}
+
+func newPathMatcher(patterns []string) (*pathMatcher, error) {
+	m := new(pathMatcher)
+	for _, pattern := range patterns {
+		re, base, err := compilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("sitemap: invalid pattern %q: %v", pattern, err)
+		}
+		m.patterns = append(m.patterns, re)
+		m.base = append(m.base, base)
+	}
+	return m, nil
+}
+
+// match returns true if the unescaped path matches any pattern of m.
+func (m *pathMatcher) match(path string) bool {
+	for i, re := range m.patterns {
+		if m.base[i] && re.MatchString(pathpkg.Base(path)) || !m.base[i] && re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// patternFilter returns the filter of entries applying opts.Include and opts.Exclude, nil if there is none.
+func patternFilter(opts *Options) (Filter, error) {
+	if len(opts.Include) == 0 && len(opts.Exclude) == 0 {
+		return nil, nil
+	}
+	include, err := newPathMatcher(opts.Include)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := newPathMatcher(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	return func(e *Entry) bool {
+		path := matchedPath(e)
+		return (len(include.patterns) == 0 || include.match(path)) && !exclude.match(path)
+	}, nil
+}
+
+// matchedPath returns the unescaped path of the location of e, matched against patterns.
+func matchedPath(e *Entry) string {
+	_, path, _ := splitLoc(e.Location)
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+	if path == "" {
+		path = "/"
+	}
+	return path
+}
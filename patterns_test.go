@@ -0,0 +1,95 @@
+package sitemap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPatternFilter(t *testing.T) {
+	opts := &Options{
+		Include: []string{"/docs/**", "/", "regexp:^/v[0-9]+/"},
+		Exclude: []string{"/docs/*/draft", "*.json"},
+	}
+	filter, err := patternFilter(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for loc, expected := range map[string]bool{
+		"http://example.com":                   true,
+		"http://example.com/":                  true,
+		"http://example.com/docs/a/b":          true,
+		"http://example.com/docs/a/draft":      false,
+		"http://example.com/docs/a/b/draft":    true,
+		"http://example.com/docs/data.json?x":  false,
+		"http://example.com/v2/api":            true,
+		"http://example.com/about":             false,
+		"http://example.com/docs/caf%C3%A9/a*": true,
+	} {
+		if filter(&Entry{FileReference: &FileReference{Location: loc}}) != expected {
+			t.Errorf("Pattern filter should return %v on %s", expected, loc)
+		}
+	}
+
+	_, err = patternFilter(&Options{Exclude: []string{"regexp:("}})
+	if err == nil {
+		t.Error("Invalid patterns should be rejected")
+	}
+}
+
+func TestRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules, err := LoadRules(strings.NewReader(`[
+		{"pattern": "/admin/**", "exclude": true},
+		{"pattern": "/blog/**", "priority": 0.8, "changefreq": "daily", "shard": "blog"},
+		{"pattern": "/**", "changefreq": "monthly"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := *DefaultOptions
+	opts.Domain = "http://example.com"
+	opts.Rules = rules
+	var locations []string
+	for _, path := range []string{"/", "/admin/users", "/blog/first"} {
+		locations = append(locations, "http://example.com"+path)
+	}
+	source := func(emit func(*Entry) error) error {
+		for _, loc := range locations {
+			err := emit(&Entry{FileReference: &FileReference{Location: loc}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err = GenerateToDir([]Source{source}, &opts, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/" || sm.Entries[0].ChangeFrequency != Monthly {
+		t.Errorf("Unexpected entries %v", sm.Entries)
+	}
+	blog := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_blog_1.xml"), blog, t)
+	if len(blog.Entries) != 1 || blog.Entries[0].ChangeFrequency != Daily || *blog.Entries[0].Priority != 0.8 {
+		t.Errorf("Unexpected entries %v", blog.Entries)
+	}
+
+	for _, invalid := range []string{`[{"pattern": "/", "changefreq": "sometimes"}]`, `[{"pattern": "/", "shard": "A/B"}]`, `[{"pattern": "/", "shard": "recent"}]`, `[{"pattern": "/", "shard": "delta"}]`, `{}`} {
+		_, err = LoadRules(strings.NewReader(invalid))
+		if err == nil {
+			t.Errorf("Rules %s should be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package sitemap
+
+import (
+	"net/http"
+	"os"
+)
+
+// Plan describes what a generation would write, see Router.Plan().
+type Plan struct {
+	Routes   []RoutePlan
+	Manifest *Manifest // files which would be written, with their size and number of urls
+}
+
+// RoutePlan is the number of entries enumerated for a route, before any filtering.
+type RoutePlan struct {
+	Pattern string
+	Entries int
+}
+
+// Plan runs a generation without writing anything, e.g. to validate the options and the routes in a CI pipeline:
+// it returns the error the generation would fail with, or what it would write.
+//
+// The stateful options (ChangeTracker, Delta, GracePeriod, Probe, Feed) and SingleSitemap are left out, and neither the stats nor
+// the circuit breaker are affected, nor events published. Every route is enumerated, as by a generation.
+func (r *Router) Plan() (*Plan, error) {
+	opts := *r.options()
+	opts.Storage = discardStorage{}
+	opts.Retry = nil
+	opts.ChangeTracker, opts.Delta, opts.GracePeriod, opts.Probe, opts.Feed = nil, nil, nil, nil, nil
+	opts.SingleSitemap = false
+	opts.Events = nil
+
+	sources, routes := r.sources(&opts, true)
+	plan := &Plan{Routes: make([]RoutePlan, len(routes))}
+	for i, route := range routes {
+		plan.Routes[i].Pattern = route.Pattern
+		counted, count := sources[i], &plan.Routes[i].Entries
+		sources[i] = func(emit func(*Entry) error) error {
+			return counted(func(e *Entry) error {
+				*count++
+				return emit(e)
+			})
+		}
+	}
+	manifest, err := generate(shardSources(sources, routes, &opts), &opts)
+	if err != nil {
+		return nil, err
+	}
+	plan.Manifest = manifest
+	return plan, nil
+}
+
+// discardStorage is a Storage without any file, where writes succeed without effect.
+type discardStorage struct{}
+
+func (discardStorage) Open(name string) (http.File, error) {
+	return nil, os.ErrNotExist
+}
+
+func (discardStorage) WriteFile(name string, data []byte) error {
+	return nil
+}
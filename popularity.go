@@ -0,0 +1,90 @@
+package sitemap
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Popularity derives the priority of entries from their number of accesses, relatively to the other entries.
+//
+// Priorities range from Min for entries never accessed, to Max for the most accessed entries, on a logarithmic scale
+// (traffic usually follows a power law, so that a linear scale would give Min to almost every entry).
+type Popularity struct {
+	Count    func(e *Entry) int // number of accesses of the entry, e.g. Router.AccessCount
+	Min, Max float64            // bounds of the priorities
+}
+
+// apply sets the priority of entries according to their popularity.
+func (p *Popularity) apply(entries []*Entry) {
+	counts := make([]int, len(entries))
+	max := 0
+	for i, e := range entries {
+		counts[i] = p.Count(e)
+		if counts[i] > max {
+			max = counts[i]
+		}
+	}
+	for i, e := range entries {
+		priority := p.Min
+		if max > 0 && counts[i] > 0 {
+			priority += (p.Max - p.Min) * math.Log1p(float64(counts[i])) / math.Log1p(float64(max))
+		}
+		e.Priority = &priority
+	}
+}
+
+// accessCounter counts accesses by path.
+type accessCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+func (c *accessCounter) add(path string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[path]++
+}
+
+func (c *accessCounter) get(path string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.counts[path]
+}
+
+// CountAccesses is a middleware counting the successful GET requests on the registered routes of the sitemap,
+// to be used as the access count of Popularity. Like LearnLastModified(), it only counts the pages of static routes,
+// and the pages enumerated by a previous generation for parameterized routes:
+//
+//	r.Use(r.CountAccesses)
+//	r.Options.Popularity = &sitemap.Popularity{Count: r.AccessCount, Min: 0.2, Max: 1}
+func (r *Router) CountAccesses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &headerRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, req)
+
+		if req.Method != "GET" || recorder.status != 0 && recorder.status != http.StatusOK {
+			return
+		}
+		if route := mux.CurrentRoute(req); route != nil && r.isSitemapPage(route, req.URL.Path) {
+			r.accesses.add(req.URL.Path)
+		}
+	})
+}
+
+// AccessCount returns the number of accesses to the page of e counted by CountAccesses().
+func (r *Router) AccessCount(e *Entry) int {
+	opts := r.options()
+	path, err := url.PathUnescape(strings.TrimPrefix(e.Location, opts.Domain+opts.ExternalPrefix))
+	if err != nil {
+		return 0
+	}
+	return r.accesses.get(path)
+}
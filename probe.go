@@ -0,0 +1,114 @@
+package sitemap
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Probe checks that the entries are actually served before listing them, e.g. for catalogs whose database
+// and rendered site may drift apart: every entry is requested with HEAD, and left out unless it replies 200 OK.
+// Redirects are not followed, so redirected entries are left out too.
+//
+// Probing keeps all entries of a generation in memory. Entries left out by the probe still count in Options.MaxURLs.
+type Probe struct {
+	Client      *http.Client  // Options.HTTPClient if nil
+	Concurrency int           // maximum number of concurrent requests (1 if zero)
+	TTL         time.Duration // duration for which results are reused by later generations, 0 to probe every time
+
+	mutex   sync.Mutex
+	results map[string]probeResult
+}
+
+// probeResult is the cached result of the probe of a url.
+type probeResult struct {
+	ok      bool
+	expires time.Time
+}
+
+// cached returns the result of the last probe of loc, if still valid at now.
+func (p *Probe) cached(loc string, now time.Time) (ok, found bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	result, found := p.results[loc]
+	if !found || !now.Before(result.expires) {
+		return false, false
+	}
+	return result.ok, true
+}
+
+func (p *Probe) cache(loc string, ok bool, now time.Time) {
+	if p.TTL <= 0 {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.results == nil {
+		p.results = make(map[string]probeResult)
+	}
+	p.results[loc] = probeResult{ok: ok, expires: now.Add(p.TTL)}
+}
+
+// client returns the client used by p, which doesn't follow redirects, based on p.Client or else on defaultClient.
+func (p *Probe) client(defaultClient *http.Client) *http.Client {
+	client := new(http.Client)
+	if p.Client != nil {
+		*client = *p.Client
+	} else {
+		*client = *defaultClient
+	}
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client
+}
+
+// probe requests loc with HEAD, and returns true if it replies 200 OK.
+func probe(client *http.Client, loc string) bool {
+	resp, err := client.Head(loc)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// filter returns the entries which pass the probe, in the same order, probed with p.Client or else with defaultClient.
+func (p *Probe) filter(entries []*Entry, now time.Time, defaultClient *http.Client) []*Entry {
+	client := p.client(defaultClient)
+	ok := make([]bool, len(entries))
+	workers := p.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				loc := entries[i].Location
+				var found bool
+				ok[i], found = p.cached(loc, now)
+				if !found {
+					ok[i] = probe(client, loc)
+					p.cache(loc, ok[i], now)
+				}
+			}
+		}()
+	}
+	for i := range entries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	kept := entries[:0]
+	for i, e := range entries {
+		if ok[i] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,111 @@
+package sitemap
+
+import (
+	"sync"
+	"time"
+)
+
+// GenerationQueue describes the generation of a Router in progress, and the calls to GenerateSitemaps() waiting for the next one.
+type GenerationQueue struct {
+	Running bool
+	Started time.Time     // start of the running generation
+	Waiting int           // number of calls waiting for a generation run by another call, or for the running one to end
+	ETA     time.Duration // estimated time until the waiting calls return (from Stats().LastDuration), 0 if none
+}
+
+// Queue returns the generation queue of r.
+func (r *Router) Queue() GenerationQueue {
+	return r.queue.get(r.options().now(), r.Stats().LastDuration)
+}
+
+// generationQueue coalesces the calls to GenerateSitemaps(): the calls made while a generation is running
+// all wait for the next generation, which starts when the running one ends and is shared by them.
+type generationQueue struct {
+	mutex   sync.Mutex
+	running *queuedGeneration
+	next    *queuedGeneration
+	waiting int // calls blocked until a generation ends
+}
+
+// queuedGeneration is a generation shared by several calls.
+type queuedGeneration struct {
+	done    chan struct{} // closed at the end of the generation
+	started time.Time
+	callers int
+	files   []string
+	err     error
+}
+
+// run returns the result of generate, called by the first caller or shared with the concurrent callers.
+func (q *generationQueue) run(generate func() ([]string, error), now func() time.Time) ([]string, error) {
+	q.mutex.Lock()
+	g := q.running
+	var previous *queuedGeneration
+	if g == nil {
+		g = &queuedGeneration{done: make(chan struct{})}
+		q.running = g
+	} else {
+		if q.next == nil {
+			q.next = &queuedGeneration{done: make(chan struct{})}
+			previous = g
+		}
+		g = q.next
+	}
+	g.callers++
+	runner := g.callers == 1
+	if !runner || previous != nil {
+		q.waiting++
+	}
+	q.mutex.Unlock()
+
+	if !runner {
+		<-g.done
+		q.mutex.Lock()
+		q.waiting--
+		q.mutex.Unlock()
+		return g.files, g.err
+	}
+	if previous != nil {
+		<-previous.done
+	}
+	q.mutex.Lock()
+	if previous != nil {
+		q.waiting--
+	}
+	g.started = now()
+	q.mutex.Unlock()
+	files, err := generate()
+	q.mutex.Lock()
+	g.files, g.err = files, err
+	q.running, q.next = q.next, nil
+	q.mutex.Unlock()
+	close(g.done)
+	return files, err
+}
+
+// get describes the queue at now, assuming generations last lastDuration.
+func (q *generationQueue) get(now time.Time, lastDuration time.Duration) GenerationQueue {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var queue GenerationQueue
+	if q.running == nil {
+		return queue
+	}
+	queue.Running = !q.running.started.IsZero()
+	queue.Started = q.running.started
+	queue.Waiting = q.waiting
+	remaining := lastDuration
+	if queue.Running {
+		remaining -= now.Sub(q.running.started)
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+	if q.next != nil {
+		remaining += lastDuration
+	}
+	if queue.Waiting > 0 {
+		queue.ETA = remaining
+	}
+	return queue
+}
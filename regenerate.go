@@ -0,0 +1,141 @@
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+)
+
+// RegenerateIndex writes the index again from the manifest, without enumerating the routes,
+// e.g. after a sitemap was uploaded again or removed by an external tool.
+// The files of the manifest are read again from the storage: the description of the files which changed is updated,
+// and the files which don't exist anymore are left out of the manifest and of the index
+// (Manifest.Shards is then left out too, as the files can't be attributed to their shards anymore).
+// The generation is recorded in r.Stats().
+//
+// All files of the generation is returned.
+func (r *Router) RegenerateIndex() ([]string, error) {
+	defer r.notifications.flush()
+	r.sitemapMutex.Lock()
+	defer r.sitemapMutex.Unlock()
+	opts := r.options()
+	start := opts.now()
+	manifest, err := regenerateIndex(opts)
+	r.fetches.reset()
+	r.stats.record(manifest, start, opts.now().Sub(start), err)
+	r.notifications.add(opts, manifest, err)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.fileNames(), nil
+}
+
+// regenerateIndex does the work of RegenerateIndex.
+func regenerateIndex(opts *Options) (*Manifest, error) {
+	storage := opts.storage()
+	manifest, err := readManifest(storage)
+	if err != nil {
+		return nil, err
+	}
+	counted := make(map[string]bool)
+	for _, name := range manifest.sitemapFiles(opts) {
+		counted[name] = true
+	}
+	files, changed := manifest.Files[:0], false
+	for _, f := range manifest.Files {
+		if f.Name == opts.indexFile() || (opts.SingleSitemap && f.Name == single_sitemap_file) {
+			continue // written below
+		}
+		data, err := readFile(storage, f.Name)
+		if os.IsNotExist(err) {
+			if counted[f.Name] {
+				manifest.URLs -= f.URLs
+			}
+			changed = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if updated := newManifestFile(f.Name, data, f.URLs); updated.SHA256 != f.SHA256 {
+			if strings.HasPrefix(f.Name, opts.sitemapPrefix()+"_") {
+				updated.URLs, err = countURLs(storage, f.Name, data)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if counted[f.Name] {
+				manifest.URLs += updated.URLs - f.URLs
+			}
+			f, changed = updated, true
+		}
+		files = append(files, f)
+	}
+	manifest.Files = files
+	if changed {
+		manifest.Shards = nil
+	}
+	if !opts.Reproducible {
+		manifest.Generated = opts.now()
+	}
+
+	locations := manifest.indexedFiles(opts)
+	fullLocations := make([]string, len(locations))
+	for i, loc := range locations {
+		fullLocations[i] = opts.Domain + opts.ExternalPrefix + opts.serverPath() + loc
+	}
+	index := NewSitemapIndex(fullLocations)
+	if !manifest.Generated.IsZero() {
+		for _, ref := range index.SitemapRefs {
+			ref.LastModification = &manifest.Generated
+			ref.LastModPrecision = opts.LastModPrecision
+		}
+	}
+	data := new(bytes.Buffer)
+	err = encodeSitemapIndexHeader(data, index, xmlHeader(opts.XMLHeader, opts.OmitXMLHeader))
+	if err != nil {
+		return nil, err
+	}
+	if opts.SingleSitemap {
+		single, err := writeSingleSitemap(storage, locations, data.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		if single.URLs < 0 {
+			single.URLs = manifest.URLs
+		}
+		manifest.Files = append(manifest.Files, single)
+	}
+	err = storage.WriteFile(opts.indexFile(), data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files = append(manifest.Files, newManifestFile(opts.indexFile(), data.Bytes(), len(locations)))
+	err = writeManifest(manifest, storage, opts.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// countURLs returns the number of entries of the sitemap name in s with the given content
+// (the number of lines of a text sitemap).
+func countURLs(s Storage, name string, data []byte) (int, error) {
+	if strings.HasSuffix(name, ".txt") {
+		urls := 0
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			if scanner.Text() != "" {
+				urls++
+			}
+		}
+		return urls, scanner.Err()
+	}
+	urls := 0
+	_, err := decodeEntries(s, name, func(*Entry) bool {
+		urls++
+		return true
+	})
+	return urls, err
+}
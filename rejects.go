@@ -0,0 +1,111 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+)
+
+const rejects_file = "rejects.json"
+
+// Reject is an entry left out of the sitemaps because it is invalid, see Options.RejectInvalid.
+type Reject struct {
+	Location string `json:"loc"`
+	Reason   string `json:"reason"`
+}
+
+// validateEntry returns the reason why e can't be written to a sitemap, or an empty string if it is valid.
+func validateEntry(e *Entry) string {
+	if e.FileReference == nil || e.Location == "" {
+		return "empty location"
+	}
+	u, err := url.Parse(e.Location)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "malformed location"
+	}
+	if e.Priority != nil && (*e.Priority < 0 || *e.Priority > 1) {
+		return fmt.Sprintf("priority %v outside [0, 1]", *e.Priority)
+	}
+	if e.ChangeFrequency != "" && !e.ChangeFrequency.Valid() {
+		return fmt.Sprintf("invalid change frequency %q", string(e.ChangeFrequency))
+	}
+	return ""
+}
+
+// writeRejects writes rejects in JSON format into s.
+func writeRejects(rejects []Reject, s Storage) error {
+	if rejects == nil {
+		rejects = []Reject{}
+	}
+	data, err := json.MarshalIndent(rejects, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.WriteFile(rejects_file, data)
+}
+
+// clampPriority replaces the priority of e by the nearest bound if it is outside [0, 1], see Options.ClampPriority.
+func clampPriority(e *Entry) {
+	if e.Priority == nil {
+		return
+	}
+	if p := *e.Priority; p < 0 || p > 1 {
+		clamped := math.Max(0, math.Min(1, p))
+		e.Priority = &clamped
+	}
+}
+
+// ValidationError is the error of a generation whose routes emitted invalid entries, see Options.ValidateEntries.
+type ValidationError struct {
+	Routes []RouteValidation // routes with invalid entries, in the order of generation
+}
+
+// RouteValidation describes the invalid entries of a route.
+type RouteValidation struct {
+	Pattern string
+	Invalid int    // number of invalid entries
+	First   Reject // first invalid entry
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sitemap: invalid entries in %d routes:", len(e.Routes))
+	for _, r := range e.Routes {
+		fmt.Fprintf(&b, " %s (%d, first %q: %s)", r.Pattern, r.Invalid, r.First.Location, r.First.Reason)
+	}
+	return b.String()
+}
+
+// source returns source, leaving out its invalid entries and recording them in e under pattern.
+func (e *ValidationError) source(pattern string, source Source, opts *Options) Source {
+	return func(emit func(*Entry) error) error {
+		return source(func(entry *Entry) error {
+			if opts.ClampPriority {
+				clampPriority(entry)
+			}
+			reason := validateEntry(entry)
+			if reason == "" {
+				return emit(entry)
+			}
+			if len(e.Routes) == 0 || e.Routes[len(e.Routes)-1].Pattern != pattern {
+				location := ""
+				if entry.FileReference != nil {
+					location = entry.Location
+				}
+				e.Routes = append(e.Routes, RouteValidation{Pattern: pattern, First: Reject{Location: location, Reason: reason}})
+			}
+			e.Routes[len(e.Routes)-1].Invalid++
+			return nil
+		})
+	}
+}
+
+// check is a source failing with e if any entry was invalid, run after the sources validated by e.
+func (e *ValidationError) check(emit func(*Entry) error) error {
+	if len(e.Routes) > 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package sitemap
+
+// Representation is an alternate representation of the pages of a route (e.g. a PDF or a print view),
+// see Router.AddRepresentation().
+type Representation struct {
+	Suffix   string  // appended to the location of the page (e.g. ".pdf", "/print" or "?print=1")
+	Priority float64 // priority of the entries of the representation
+}
+
+// AddRepresentation adds a representation of the pages of the route registered with pattern: for each entry
+// of the route, an entry at its location followed by suffix is written right after it, with the given priority,
+// without enumerating the route again.
+func (r *Router) AddRepresentation(pattern, suffix string, priority float64) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		representations := make([]Representation, len(s.Representations), len(s.Representations)+1)
+		copy(representations, s.Representations)
+		s.Representations = append(representations, Representation{Suffix: suffix, Priority: priority})
+	})
+}
+
+// withRepresentations returns source, emitting the representations after each entry.
+func withRepresentations(representations []Representation, source Source) Source {
+	return func(emit func(*Entry) error) error {
+		return source(func(e *Entry) error {
+			location, lastmod, metadata := e.Location, e.LastModification, e.Metadata
+			err := emit(e)
+			if err != nil {
+				return err
+			}
+			for i := range representations {
+				representation := newEntry(location + representations[i].Suffix)
+				representation.LastModification = lastmod
+				representation.Priority = &representations[i].Priority
+				representation.Metadata = metadata
+				err := emit(representation)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package sitemap
+
+import (
+	"sort"
+	"time"
+)
+
+// byLocation sorts entries by location.
+type byLocation []*Entry
+
+func (b byLocation) Len() int           { return len(b) }
+func (b byLocation) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byLocation) Less(i, j int) bool { return b[i].Location < b[j].Location }
+
+// sortEntries sorts entries by location, keeping the enumeration order of duplicates.
+func sortEntries(entries []*Entry) {
+	sort.Stable(byLocation(entries))
+}
+
+// latestModification returns the latest LastModification of all entries,
+// or the zero time if no entry has one.
+func latestModification(entries []*Entry) time.Time {
+	var latest time.Time
+	for _, e := range entries {
+		if e.LastModification != nil && e.LastModification.After(latest) {
+			latest = *e.LastModification
+		}
+	}
+	return latest
+}
@@ -0,0 +1,44 @@
+package sitemap
+
+import (
+	"time"
+)
+
+// Retry configures the retries of failed writes to the storage, with exponential backoff.
+// During generations, the delays elapse on Options.Clock.
+type Retry struct {
+	Attempts int           // maximum number of attempts per write (1 if zero)
+	Delay    time.Duration // delay before the first retry, doubled after each failed attempt
+	MaxDelay time.Duration // maximum delay between two attempts, no maximum if zero
+}
+
+// retryStorage retries the failed writes of a Storage.
+type retryStorage struct {
+	Storage
+	retry *Retry
+	clock Clock // clock of the delays
+}
+
+// RetryStorage returns a Storage retrying the failed writes to s as configured by retry.
+// A write fails only after the last attempt failed, with the error of that attempt.
+func RetryStorage(s Storage, retry *Retry) Storage {
+	return &retryStorage{
+		Storage: s,
+		retry:   retry,
+		clock:   SystemClock,
+	}
+}
+
+func (s *retryStorage) WriteFile(name string, data []byte) error {
+	delay := s.retry.Delay
+	err := s.Storage.WriteFile(name, data)
+	for attempt := 1; err != nil && attempt < s.retry.Attempts; attempt++ {
+		sleep(s.clock, delay)
+		delay *= 2
+		if s.retry.MaxDelay > 0 && delay > s.retry.MaxDelay {
+			delay = s.retry.MaxDelay
+		}
+		err = s.Storage.WriteFile(name, data)
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package sitemap
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// flakyStorage fails the first writes.
+type flakyStorage struct {
+	DirStorage
+	failures int
+	attempts int
+}
+
+func (s *flakyStorage) WriteFile(name string, data []byte) error {
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("transient failure")
+	}
+	return s.DirStorage.WriteFile(name, data)
+}
+
+// delayTimer is a Timer recording the delays of AfterFunc, which calls the functions right away.
+type delayTimer struct {
+	Clock
+	delays []time.Duration
+}
+
+func (c *delayTimer) AfterFunc(d time.Duration, f func()) {
+	c.delays = append(c.delays, d)
+	f()
+}
+
+func TestRetryStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	flaky := &flakyStorage{DirStorage: DirStorage(dir), failures: 2}
+	s := RetryStorage(flaky, &Retry{Attempts: 3, Delay: time.Millisecond})
+	err = s.WriteFile("file", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flaky.attempts != 3 {
+		t.Errorf("Expecting 3 attempts but got %d", flaky.attempts)
+	}
+
+	flaky = &flakyStorage{DirStorage: DirStorage(dir), failures: 3}
+	s = RetryStorage(flaky, &Retry{Attempts: 3, Delay: time.Millisecond})
+	err = s.WriteFile("file", []byte("data"))
+	if err == nil {
+		t.Error("Expecting an error after the last attempt")
+	}
+
+	timer := &delayTimer{Clock: SystemClock}
+	flaky = &flakyStorage{DirStorage: DirStorage(dir), failures: 3}
+	s = &retryStorage{Storage: flaky, retry: &Retry{Attempts: 4, Delay: time.Second, MaxDelay: 3 * time.Second}, clock: timer}
+	err = s.WriteFile("file", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}; !reflect.DeepEqual(timer.delays, expected) {
+		t.Errorf("Expecting the delays %v on the clock but got %v", expected, timer.delays)
+	}
+}
@@ -20,7 +20,10 @@ Example of use:
 
 ... or a secret route (i.e. not appearing in the sitemap):
 
-		r.HandleFunc("/my/secret/route", f)
+		r.RegisterSecret("/my/secret/route").HandlerFunc(f)
+
+Secret routes can also be handled without registration (e.g. with r.HandleFunc), but r.Coverage()
+then reports them as unlisted rather than secret.
 
 3. Parameterized route:
 
@@ -87,10 +90,16 @@ and (r.Options.ServerPath + "sitemap_1.xml") returns:
 package sitemap
 
 import (
-	"html"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"net/http"
+	"reflect"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -103,36 +112,288 @@ import (
 type Router struct {
 	*mux.Router
 	sitemapMutex  sync.RWMutex
+	routesMutex   sync.RWMutex // guards staticEntries and paramEntries
 	staticEntries []*path
 	paramEntries  []*paramPath
-	Options       *Options
+	secretRoutes  []string // patterns of RegisterSecret() and handleFiles()
+	files         []*fileRoutes
+	conflicts     []error // duplicate registrations
+	serving       sync.Once
+	served        int32 // set atomically once the file routes are final, see ServeHTTP()
+	optionsMutex  sync.RWMutex
+	stats         statsRecorder
+	fetches       fetchRecorder
+	lastMods      lastModStore
+	accesses      accessCounter
+	enumerated    pathSet
+	circuits      circuits
+	queue         generationQueue
+	notifications notificationQueue
+	Options       *Options // modify only before serving, use UpdateOptions() afterwards
 }
 
 // Options is used by Router.
 type Options struct {
 	CachePath       string  // path of a directory, to store sitemaps on disk
-	ServerPath      string  // server path for sitemaps
+	ServerPath      string  // server path for sitemaps, with a leading and a trailing slash (added if missing)
 	DefaultPriority float64 // default priority for sitemap entries
-	Domain          string  // domain for entries in the sitemap (multiple domains are not supported)
+	Domain          string  // domain for entries in the sitemap, derived from the first request on one of AllowedHosts if empty
+	Clock           Clock   // source of the current time (SystemClock if nil)
+	Storage         Storage // where generated files are stored and served from (DirStorage(CachePath) if nil)
+	Retry           *Retry  // retries of failed writes to the storage during generation (no retry if nil)
+
+	// HTTPClient is the client of the requests made by the router (e.g. by Probe), http.DefaultClient if nil,
+	// to set timeouts, proxies or TLS configuration in one place.
+	HTTPClient *http.Client
+
+	// Notifier, if set, is notified at the end of every generation (see ThrottleNotifier to notify search engines sparingly).
+	// It is called once the sitemaps are unlocked, so that it doesn't delay serving them.
+	Notifier Notifier
+	// Events, if set, receives the lifecycle events of the sitemaps (generations, shards, rejects, serve errors).
+	Events *EventBus
+
+	// TracingHeaders adds a Server-Timing header to the sitemap replies (cache hit or miss, duration of the generation
+	// made for the request, age of the files served), and echoes the W3C trace context of the request (traceparent)
+	// in a Traceresponse header, to correlate crawler fetches with the backend when debugging.
+	TracingHeaders bool
+
+	// XMLHeader replaces xml.Header at the beginning of the generated sitemaps and index, e.g. StandaloneXMLHeader.
+	// OmitXMLHeader leaves the XML declaration out, e.g. for pipelines post-processing the files.
+	XMLHeader     string
+	OmitXMLHeader bool
+
+	// PriorityPrecision, if set, rounds the priorities written in the sitemaps to this number of decimals,
+	// e.g. 2 to always write "0.80". By default, priorities are written exactly, with the fewest digits ("0.8", "0.75", "1").
+	// Priorities are always written in plain decimal notation.
+	PriorityPrecision int
+	// OmitDefaultPriority writes the entries with a priority of 0.5, the default of the sitemap protocol,
+	// without the priority element, shrinking large sitemaps (crawlers assume 0.5, and Google ignores priorities).
+	OmitDefaultPriority bool
+
+	// ReadOnly makes the handlers serve the files already in the storage only, replying 503 until they exist,
+	// e.g. for instances serving traffic while a dedicated worker generates the sitemaps into a shared storage.
+	// Explicit generations (GenerateSitemaps, Manager.Run) are not affected.
+	ReadOnly bool
+
+	// CircuitBreaker, if set, reuses the last entries of routes whose enumeration fails, instead of failing the generation.
+	CircuitBreaker *CircuitBreaker
+
+	// Reproducible makes the generated files depend on the registered entries only:
+	// entries are sorted by location, and the index lastmod is the latest lastmod of all entries
+	// (omitted if no entry has one) instead of the current time.
+	// Enumerators must be deterministic for the output to be byte-identical.
+	Reproducible bool
+
+	// HashFileNames names the sitemaps sitemap_<hash>.xml, where <hash> depends on the content only.
+	// A changed sitemap gets a new name, so CDNs pick it up without purging; the index keeps its name.
+	HashFileNames bool
+
+	// Schema, if set, is the schema of the sitemaps instead of SitemapSchema,
+	// e.g. a copy of SitemapSchema without the xsi attributes, or with the namespaces of extensions.
+	Schema *Schema
+
+	// LastModPrecision is the precision of the lastmod dates, unless set on entries (see FileReference.LastModPrecision).
+	// The default keeps fractional seconds; some tools expect SecondPrecision or DayPrecision.
+	LastModPrecision DatePrecision
+
+	// SingleSitemap also writes sitemap.xml: a copy of the sitemap while all entries fit in a single one,
+	// and a copy of the index afterwards, so that sitemap.xml can be submitted to search engines whatever the size of the site.
+	SingleSitemap bool
+
+	// IndexFile is the name of the sitemap index, "sitemapindex.xml" if empty (e.g. "sitemap.xml", then without SingleSitemap).
+	// SitemapPrefix is the beginning of the names of the sitemaps, "sitemap" if empty: they are named <prefix>_<n>.xml.
+	// The routes registered by HandleSitemaps() follow both names.
+	IndexFile     string
+	SitemapPrefix string
+
+	// TextSitemaps also writes every sitemap in the text format (one url per line), named sitemap_<n>.txt,
+	// for the tools which don't read XML.
+	TextSitemaps bool
+
+	// Resume makes generations resumable: the shards (see Tag()) are written one after the other, each recorded in journal.json
+	// once complete, so that a generation interrupted by a crash or a deploy reuses the shards completed by the previous one
+	// instead of enumerating them again. Resume is ignored with options mixing entries of several shards
+	// (Reproducible, Popularity, Probe, Feed, RecentWindow, ChangeTracker, Delta, GracePeriod, and Rules with shards).
+	Resume bool
+
+	// RecentWindow, if positive, also writes the entries modified within RecentWindow before the generation
+	// into sitemap_recent_<n>.xml, referenced by the index, to direct crawlers to fresh content.
+	RecentWindow time.Duration
+
+	// Delta, if set, also writes the entries added or modified since the previous generation into sitemap_delta_<n>.xml.
+	Delta *Delta
+
+	// Feed, if set, also writes Atom and RSS feeds of the most recently modified entries (atom.xml and rss.xml).
+	Feed *Feed
+
+	// Compression enables gzip compression of the sitemaps if non-nil (e.g. DefaultCompression).
+	Compression *Compression
+
+	// Filters are applied to every entry of every source during generation:
+	// an entry is left out of the sitemaps if any filter returns false. Filters must not keep the entries.
+	Filters []Filter
+
+	// Include and Exclude select entries by path, e.g. []string{"/admin/**", "*.json"}, without changing registrations.
+	// If Include is set, only the entries matching one of its patterns are kept; entries matching a pattern of Exclude are left out.
+	// Patterns are globs ("*" and "?" don't match "/", "**" matches anything; patterns without "/" match
+	// the last segment of the path), or regular expressions if prefixed by "regexp:" (e.g. "regexp:^/v[0-9]+/").
+	// Paths are matched unescaped.
+	Include []string
+	Exclude []string
+
+	// Rules set the priority, the change frequency or the shard of the entries whose path matches their pattern,
+	// or leave them out, e.g. loaded from a configuration file with LoadRules(). The first matching rule applies.
+	Rules []Rule
+
+	// OnEntry, if set, is called for every entry once the filters, the rules and the other options of the entry are applied
+	// (e.g. to rewrite hosts or query parameters in one place), and before ClampPriority and RejectInvalid.
+	// It returns the entry to write, e or another one, or nil to leave it out (of the sitemaps and of the feeds).
+	// Probe and Popularity apply afterwards, to the entries it returns.
+	OnEntry func(e *Entry) *Entry
+
+	// Popularity, if set, replaces the priority of every entry by a priority reflecting its popularity.
+	// All entries of a generation are then kept in memory.
+	Popularity *Popularity
+
+	// TrailingSlash adds or strips the trailing slash of the path of every entry (kept as enumerated by default),
+	// so that "/about" and "/about/" never both appear.
+	TrailingSlash TrailingSlash
+
+	// LowercaseHosts lowercases the scheme and host of every entry.
+	// LowercasePaths lowercases their paths (but not their queries), except for routes exempted with Router.KeepPathCase().
+	// This avoids duplicate urls differing in case only when enumerators don't agree on it.
+	LowercaseHosts bool
+	LowercasePaths bool
+
+	// Canonical, if set, leaves out every entry whose canonical url differs from its location,
+	// so that only canonical urls are listed.
+	// With ReplaceNonCanonical, such entries are listed under their canonical url instead, and duplicates are left out
+	// (all locations of a generation are then kept in memory).
+	Canonical           CanonicalResolver
+	ReplaceNonCanonical bool
+
+	// Alternates, if set, adds alternates to every entry during generation, e.g. AMPSuffix("/amp").
+	// Alternates can also be set on the entries of sources.
+	Alternates AlternatesFunc
+
+	// ChangeTracker, if set, sets the change frequency of entries from the changes of their lastmod
+	// across generations (see ChangeTracker).
+	ChangeTracker *ChangeTracker
+
+	// GracePeriod, if set, keeps the urls no longer emitted by their source in the sitemaps for a few generations
+	// (see GracePeriod), smoothing over transient failures of the data sources.
+	GracePeriod *GracePeriod
+
+	// Probe, if set, leaves out the entries which are not actually served (see Probe).
+	Probe *Probe
+
+	// OnlyTags, if set, restricts generations to the routes having at least one of these tags (see Router.Tag).
+	OnlyTags []string
+
+	// ClampLastMod replaces the lastmod of entries in the future by the start of the generation, as future dates
+	// (clock skew, bad data) make crawlers distrust the field. Such entries are counted in Stats.ClampedLastMods.
+	ClampLastMod bool
+
+	// RejectInvalid leaves out the entries which can't be written to a sitemap (empty or malformed location,
+	// priority outside [0, 1], invalid change frequency) instead of failing the generation,
+	// and lists them with the reason in rejects.json (written next to the manifest at every generation),
+	// so that their source records can be fixed.
+	RejectInvalid bool
+
+	// ValidateEntries makes GenerateSitemaps fail with a *ValidationError listing the routes which emitted invalid entries
+	// (empty or malformed location, priority outside [0, 1], invalid change frequency) once all routes are enumerated,
+	// before the index is written, instead of failing at the first invalid priority or writing invalid locations.
+	// It has no effect with RejectInvalid, which leaves such entries out.
+	ValidateEntries bool
+	// ClampPriority replaces the priorities outside [0, 1] by the nearest bound instead of treating the entries as invalid.
+	ClampPriority bool
+
+	// SigningKey, if set, signs manifest.json at every generation and writes the base64-encoded signature
+	// to manifest.json.sig. As the manifest records the hash of every file, mirrors can check their copy
+	// of the sitemaps with Verify() and the public key.
+	SigningKey ed25519.PrivateKey
+
+	// MaxURLs is the maximum number of entries in a generation, 0 for no limit.
+	// Entries beyond the limit are dropped (see Stats.DroppedURLs).
+	MaxURLs int
+	// RefreshInterval is the time after which a Manager regenerates the sitemaps (see Manager.Run), 0 for never.
+	RefreshInterval time.Duration
+
+	// TrustForwardedHost takes the domain of lazily generated sitemaps from the request triggering the generation,
+	// as reported by a reverse proxy in the Forwarded or X-Forwarded-Host header, instead of Domain.
+	// The host must be listed in AllowedHosts ("*.example.com" allows all subdomains); Domain is used otherwise.
+	// AllowedHosts also restricts the hosts Domain is derived from when it is empty: the Host header is chosen by the client,
+	// so without Domain nor AllowedHosts, no domain is derived and lazy generations fail (see ServeError).
+	// The scheme is the one of Domain (http if none).
+	TrustForwardedHost bool
+	AllowedHosts       []string
+	// TrustForwardedProto takes the scheme of the domain of lazily generated sitemaps from
+	// the Forwarded or X-Forwarded-Proto header (http or https), e.g. behind a TLS-terminating load balancer.
+	TrustForwardedProto bool
+
+	// StrictHost restricts the routes registered by HandleSitemaps() and similar methods to the host of Domain,
+	// so that the sitemaps listing the urls of a host are never served on another one (e.g. an alias of the site,
+	// or another site served by the same server, which gets its own sitemaps from its own Router, see Manager).
+	// It has no effect if Domain is empty.
+	StrictHost bool
+
+	// ExternalPrefix is the path prefix under which a reverse proxy mounts the router (e.g. "/blog", no trailing slash).
+	// It is inserted between the domain and the path of every url in the sitemaps and the index.
+	ExternalPrefix string
+	// TrustForwardedPrefix takes ExternalPrefix from the X-Forwarded-Prefix header of the request
+	// triggering a lazy generation, if present.
+	TrustForwardedPrefix bool
 }
 
 // DefaultOptions is the default options used when calling NewRouter().
 var DefaultOptions = &Options{
 	ServerPath:      "/",
 	DefaultPriority: 0.5,
+	Clock:           SystemClock,
+}
+
+// storage returns o.Storage, or the cache directory if nil.
+func (o *Options) storage() Storage {
+	if o.Storage == nil {
+		return DirStorage(o.CachePath)
+	}
+	return o.Storage
+}
+
+// httpClient returns o.HTTPClient, or http.DefaultClient if nil.
+func (o *Options) httpClient() *http.Client {
+	if o.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return o.HTTPClient
+}
+
+// now returns the current time according to o.Clock.
+func (o *Options) now() time.Time {
+	return o.clock().Now()
+}
+
+// clock returns o.Clock, or SystemClock if nil.
+func (o *Options) clock() Clock {
+	if o.Clock == nil {
+		return SystemClock
+	}
+	return o.Clock
 }
 
 // path represents a static route.
 type path struct {
-	Priority float64
 	Location string
+	Route    *mux.Route
+	routeSettings
 }
 
 // paramPath represents a parameterized route.
 type paramPath struct {
-	Priority   float64
+	Pattern    string
 	Route      *mux.Route
-	Enumerator VariableEnumerator
+	Enumerator EntryEnumerator
+	routeSettings
 }
 
 // VariableEnumerator calls the callback as many times as there are routes allowed.
@@ -142,6 +403,24 @@ type paramPath struct {
 // See the package's main documentation for an example.
 type VariableEnumerator func(callback func(pairs ...string) error) error
 
+// Attributes are the attributes of an entry of a parameterized route, given by an AttributeEnumerator.
+// Zero values keep the attributes of the route (see SetPriority() and SetChangeFrequency())
+// and the lastmod learned from the responses (see LearnLastModified()).
+type Attributes struct {
+	LastModification *time.Time
+	Priority         *float64
+	ChangeFrequency  ChangeFrequency
+}
+
+// AttributeEnumerator is a VariableEnumerator whose callback also takes the attributes of the entry of the variables,
+// e.g. the lastmod of the content known by the enumerator.
+type AttributeEnumerator func(callback func(attrs Attributes, pairs ...string) error) error
+
+// EntryEnumerator is a VariableEnumerator whose callback also takes the entry of the variables (possibly nil),
+// for sources knowing all about their entries. The location of e is set from the variables, and its unset attributes
+// (lastmod, priority, change frequency, metadata) are those of the route, as with Attributes. e is not modified.
+type EntryEnumerator func(emit func(pairs []string, e *Entry) error) error
+
 // NewRouter wraps router into a new Router, ready to register sitemap urls for the given domain.
 //
 // localPath is the path where to store the sitemaps when created.
@@ -150,7 +429,7 @@ type VariableEnumerator func(callback func(pairs ...string) error) error
 //
 //     r := NewRouter(router, "example.com", "cache/sitemaps")
 //     r.Options.DefaultPriority = 1
-//     r.ServerPath = "/sitemaps/" // don't forget the trailing slash!
+//     r.Options.ServerPath = "/sitemaps/" // "sitemaps" would be normalized to "/sitemaps/"
 func NewRouter(router *mux.Router, domain, localPath string) *Router {
 	if !strings.HasSuffix(localPath, "/") {
 		localPath += "/"
@@ -165,88 +444,296 @@ func NewRouter(router *mux.Router, domain, localPath string) *Router {
 	}
 }
 
+// UpdateOptions calls update on a copy of the current options, and makes the copy current.
+//
+// Unlike modifying r.Options directly (which is only safe before serving sitemaps), it is safe to call UpdateOptions at any time:
+// requests and generations already underway keep the previous options, subsequent ones get the new options.
+//
+// Routes registered by HandleSitemaps() (and the like) are final once r serves its first request:
+// afterwards, UpdateOptions keeps the current options and returns ErrRoutesChanged if update changes
+// ServerPath, IndexFile or SitemapPrefix.
+func (r *Router) UpdateOptions(update func(o *Options)) error {
+	r.optionsMutex.Lock()
+	defer r.optionsMutex.Unlock()
+	options := new(Options)
+	*options = *r.Options
+	update(options)
+	if atomic.LoadInt32(&r.served) != 0 && (options.serverPath() != r.Options.serverPath() || sitemapRoute(options) != sitemapRoute(r.Options)) {
+		return ErrRoutesChanged
+	}
+	r.Options = options
+	return nil
+}
+
+// ErrRoutesChanged is returned by UpdateOptions for changes to the routes of the sitemap files while they are served.
+var ErrRoutesChanged = errors.New("sitemap: ServerPath, IndexFile and SitemapPrefix can't change once the sitemaps are served")
+
+// options returns the current options. The result must not be modified.
+func (r *Router) options() *Options {
+	r.optionsMutex.RLock()
+	defer r.optionsMutex.RUnlock()
+	return r.Options
+}
+
 // Register creates a static route (no variables in the path) and adds it to the sitemap.
-func (r *Router) Register(pattern string) *mux.Route {
+//
+// Registering routes while sitemaps are generated or served is safe as far as the sitemap is concerned:
+// generations use a snapshot of the routes registered when they start.
+// Concurrent registrations are serialized, but note that the embedded mux.Router must not be modified
+// while it serves requests.
+//
+// A pattern already registered is not added to the sitemap again, see Conflicts().
+//
+// The returned SitemapRoute is the mux.Route, which also sets the sitemap settings of the route, e.g.
+//
+//	r.Register("/about").Priority(0.8).Handler(handler)
+func (r *Router) Register(pattern string) *SitemapRoute {
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+	route := &SitemapRoute{Route: r.Path(pattern), router: r, pattern: pattern}
+	if r.conflict(pattern, "Register") {
+		return route
+	}
 	r.staticEntries = append(r.staticEntries, &path{
-		Location: pattern,
-		Priority: r.Options.DefaultPriority,
+		Location:      pattern,
+		Route:         route.Route,
+		routeSettings: routeSettings{Priority: r.options().DefaultPriority},
 	})
-	return r.Path(pattern)
+	return route
 }
 
 // RegisterParam creates a route with parameters (=variables) in the path.
 // Each time the sitemap is (re-)created, enum is called to get the list of allowed variable values.
 //
 // See the package's main documentation for an example.
-func (r *Router) RegisterParam(pattern string, enum VariableEnumerator) *mux.Route {
-	route := r.Path(pattern)
+//
+// A pattern already registered is not added to the sitemap again, see Conflicts().
+// The returned SitemapRoute is the mux.Route, as for Register().
+func (r *Router) RegisterParam(pattern string, enum VariableEnumerator) *SitemapRoute {
+	return r.registerParam(pattern, "RegisterParam", func(emit func([]string, *Entry) error) error {
+		return enum(func(pairs ...string) error {
+			return emit(pairs, nil)
+		})
+	})
+}
+
+// RegisterParamAttributes creates a route with parameters like RegisterParam(),
+// with an enumerator also giving the attributes of each entry (lastmod, priority, change frequency).
+func (r *Router) RegisterParamAttributes(pattern string, enum AttributeEnumerator) *SitemapRoute {
+	return r.registerParam(pattern, "RegisterParamAttributes", func(emit func([]string, *Entry) error) error {
+		return enum(func(attrs Attributes, pairs ...string) error {
+			return emit(pairs, &Entry{
+				FileReference:   &FileReference{LastModification: attrs.LastModification},
+				Priority:        attrs.Priority,
+				ChangeFrequency: attrs.ChangeFrequency,
+			})
+		})
+	})
+}
+
+// RegisterParamEntries creates a route with parameters like RegisterParam(),
+// with an enumerator also giving the entries of the variables, e.g. with their alternates.
+func (r *Router) RegisterParamEntries(pattern string, enum EntryEnumerator) *SitemapRoute {
+	return r.registerParam(pattern, "RegisterParamEntries", enum)
+}
+
+// registerParam does the work of the RegisterParam methods.
+func (r *Router) registerParam(pattern, method string, enum EntryEnumerator) *SitemapRoute {
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+	route := &SitemapRoute{Route: r.Path(pattern), router: r, pattern: pattern}
+	if r.conflict(pattern, method) {
+		return route
+	}
 	r.paramEntries = append(r.paramEntries, &paramPath{
-		Route:      route,
-		Priority:   r.Options.DefaultPriority,
-		Enumerator: enum,
+		Pattern:       pattern,
+		Route:         route.Route,
+		Enumerator:    enum,
+		routeSettings: routeSettings{Priority: r.options().DefaultPriority},
 	})
 	return route
 }
 
-func (r *Router) fullLocation(absPath string) string {
-	return r.Options.Domain + html.EscapeString(absPath)
+func fullLocation(opts *Options, absPath string) string {
+	return opts.Domain + opts.ExternalPrefix + absPath
+}
+
+// registered returns a snapshot of the registered routes.
+func (r *Router) registered() ([]*path, []*paramPath) {
+	r.routesMutex.RLock()
+	defer r.routesMutex.RUnlock()
+	static := make([]*path, len(r.staticEntries))
+	copy(static, r.staticEntries)
+	params := make([]*paramPath, len(r.paramEntries))
+	copy(params, r.paramEntries)
+	return static, params
+}
+
+// sources returns one Source per registered route in the order of Routes(), along with the description of the routes.
+// Disabled routes are left out. If reuse is set, the parameterized routes reuse their entries once emit returns,
+// for the generations, which copy the entries they keep.
+func (r *Router) sources(opts *Options, reuse bool) ([]Source, []RouteInfo) {
+	static, params := enabledRoutes(r.registered())
+	sources := make([]Source, 0, len(static)+len(params))
+	for _, entry := range static {
+		sources = append(sources, r.staticSource(opts, entry))
+		if len(entry.Representations) > 0 {
+			sources[len(sources)-1] = withRepresentations(entry.Representations, sources[len(sources)-1])
+		}
+		if opts.LowercasePaths && !entry.KeepPathCase {
+			sources[len(sources)-1] = lowercasePaths(sources[len(sources)-1])
+		}
+	}
+	for _, entry := range params {
+		sources = append(sources, r.paramSource(opts, entry, reuse))
+		if entry.MaxEntries > 0 {
+			sources[len(sources)-1] = limitEntries(entry.Pattern, entry.MaxEntries, sources[len(sources)-1])
+		}
+		if len(entry.Representations) > 0 {
+			sources[len(sources)-1] = withRepresentations(entry.Representations, sources[len(sources)-1])
+		}
+		if opts.LowercasePaths && !entry.KeepPathCase {
+			sources[len(sources)-1] = lowercasePaths(sources[len(sources)-1])
+		}
+	}
+	routes := routeInfos(static, params)
+	sortRoutes(sources, routes)
+	return sources, routes
+}
+
+// staticSource returns a Source yielding the single entry of a static route.
+func (r *Router) staticSource(opts *Options, entry *path) Source {
+	return func(emit func(*Entry) error) error {
+		location, lastModKey := entry.Location, entry.Location
+		if entry.URLBuilder != nil {
+			u, err := entry.buildURL(entry.Route)
+			if err != nil {
+				return &RouteError{Pattern: entry.Location, Err: err}
+			}
+			location, lastModKey = u.String(), u.Path
+		}
+		e := &Entry{
+			FileReference: &FileReference{
+				Location:         fullLocation(opts, location),
+				LastModification: r.lastMods.get(lastModKey),
+			},
+			ChangeFrequency: entry.changeFrequency(),
+			Metadata:        entry.Metadata,
+		}
+		e.Priority = entry.priority(e)
+		return emit(e)
+	}
+}
+
+// paramSource returns a Source yielding one entry per set of variables enumerated for a parameterized route.
+// If reuse is set, the same entry is emitted again and again, with the values of each set of variables.
+func (r *Router) paramSource(opts *Options, entry *paramPath, reuse bool) Source {
+	return func(emit func(*Entry) error) error {
+		var scratch *Entry
+		if reuse {
+			scratch = newEntry("")
+		}
+		return entry.Enumerator(func(pairs []string, given *Entry) error {
+			route, err := entry.buildURL(entry.Route, pairs...)
+			if err != nil {
+				return &RouteError{Pattern: entry.Pattern, Pairs: pairs, Err: err}
+			}
+			r.enumerated.add(route.Path)
+			e := scratch
+			if e == nil {
+				e = newEntry(fullLocation(opts, route.String()))
+			} else {
+				ref := e.FileReference
+				*ref = FileReference{Location: fullLocation(opts, route.String())}
+				*e = Entry{FileReference: ref}
+			}
+			if given != nil {
+				ref := e.FileReference
+				*e = *given
+				e.FileReference = ref
+				if given.FileReference != nil {
+					ref.LastModification, ref.LastModPrecision = given.LastModification, given.LastModPrecision
+				}
+			}
+			if e.LastModification == nil {
+				e.LastModification = r.lastMods.get(route.Path)
+			}
+			if e.Metadata == nil {
+				e.Metadata = entry.Metadata
+			}
+			if e.Priority == nil {
+				e.Priority = entry.priority(e)
+			}
+			if e.ChangeFrequency == "" {
+				e.ChangeFrequency = entry.changeFrequency(pairs...)
+			}
+			return emit(e)
+		})
+	}
 }
 
 // GenerateSitemaps creates sitemapindex.xml and as many sitemaps as needed.
 // Since there are size restrictions on a sitemap, there may be more than one.
-// In this case they are named sitemap_1.xml, sitemap_2.xml, and so on.
+// In this case they are named sitemap_1.xml, sitemap_2.xml, and so on
+// (or sitemap_<hash>.xml if r.Options.HashFileNames is set).
+//
+// A manifest.json describing the generation is written alongside (see Manifest).
 //
-// All files created is returned (paths relative to r.Options.CachePath).
+// All files created is returned (paths relative to r.Options.CachePath, or to the root of r.Options.Storage).
 //
 // It is safe to call GenerateSitemaps() even when they are served due to a call to HandleSitemaps().
 // A read-write lock takes care of queueing requests until the sitemaps are generated.
+//
+// Calls made while a generation is running don't queue one after another: they all wait for the next generation,
+// which they share (see Queue()).
 func (r *Router) GenerateSitemaps() ([]string, error) {
-	r.sitemapMutex.Lock()
-	defer r.sitemapMutex.Unlock()
+	return r.queue.run(func() ([]string, error) {
+		defer r.notifications.flush()
+		r.sitemapMutex.Lock()
+		defer r.sitemapMutex.Unlock()
+		return r.generateSitemaps(r.options())
+	}, r.options().now)
+}
 
-	buffer := NewBuffer(r.Options.Domain, r.Options.CachePath)
-	for _, entry := range r.staticEntries {
-		buffer.AddEntry(&Entry{
-			FileReference: &FileReference{
-				Location: r.fullLocation(entry.Location),
-			},
-			Priority: &entry.Priority,
-		})
-	}
-	for _, entry := range r.paramEntries {
-		err := entry.Enumerator(func(pairs ...string) error {
-			route, err := entry.Route.URL(pairs...)
-			if err != nil {
-				return err
-			}
-			return buffer.AddEntry(&Entry{
-				FileReference: &FileReference{
-					Location: r.fullLocation(route.String()),
-				},
-				Priority: &entry.Priority,
-			})
-		})
-		if err != nil {
-			return nil, err
+// generateSitemaps does the work of GenerateSitemaps with the given options.
+// The caller must hold the write lock, unless the storage of opts is not shared (see streamingHandler),
+// and call r.notifications.flush() once it is released.
+func (r *Router) generateSitemaps(opts *Options) ([]string, error) {
+	start := opts.now()
+	// the circuit breaker keeps the entries of the routes to emit them again
+	sources, routes := r.sources(opts, opts.CircuitBreaker == nil)
+	if opts.CircuitBreaker != nil {
+		for i, route := range routes {
+			sources[i] = r.circuits.protect(route.Pattern, sources[i], opts)
 		}
 	}
-	err := buffer.Flush()
-	if err != nil {
-		return nil, err
+	routeStats := make([]RouteStats, len(routes))
+	for i, route := range routes {
+		routeStats[i].Pattern = route.Pattern
+		sources[i] = measureSource(sources[i], &routeStats[i], opts)
 	}
-
-	fullLocations := make([]string, len(buffer.Locations))
-	for i, loc := range buffer.Locations {
-		fullLocations[i] = r.Options.Domain + r.Options.ServerPath + loc
+	var validation *ValidationError
+	if opts.ValidateEntries && !opts.RejectInvalid {
+		validation = new(ValidationError)
+		for i, route := range routes {
+			sources[i] = validation.source(route.Pattern, sources[i], opts)
+		}
 	}
-
-	index := NewSitemapIndex(fullLocations)
-	path := "sitemapindex.xml"
-	err = index.WriteToFile(r.Options.CachePath + path)
+	shards := shardSources(sources, routes, opts)
+	if validation != nil && len(shards) > 0 {
+		// fail once every route is validated, before the index is written
+		last := shards[len(shards)-1]
+		last.sources = append(last.sources, validation.check)
+	}
+	manifest, err := generate(shards, opts)
+	r.fetches.reset()
+	r.stats.record(manifest, start, opts.now().Sub(start), err)
+	r.stats.recordRoutes(routeStats)
+	r.notifications.add(opts, manifest, err)
 	if err != nil {
 		return nil, err
 	}
-	return append(buffer.Locations, path), nil
+	return manifest.fileNames(), nil
 }
 
 // HandleSitemaps register routes to serve the sitemap files on the router. The http handler is returned.
@@ -254,13 +741,152 @@ func (r *Router) GenerateSitemaps() ([]string, error) {
 // All routes registered are:
 //     r.Options.ServerPath + "sitemapindex.xml"
 //     r.Options.ServerPath + "sitemap_%d.xml" // where %d is a replaced by a positive integer.
+//     r.Options.ServerPath + "sitemap_%s.xml" // where %s is a content hash, if r.Options.HashFileNames is set.
+//     r.Options.ServerPath + "sitemap_%d.xml.gz" // (or hashed) if r.Options.Compression is set.
+//     r.Options.ServerPath + "sitemap_%s_%d.xml" // where %s are the tags of the routes, see Tag().
+//     r.Options.ServerPath + "sitemap.xml" // if r.Options.SingleSitemap is set.
+//     r.Options.ServerPath + "sitemap_%d.txt" // if r.Options.TextSitemaps is set.
+//     r.Options.ServerPath + "sitemap_recent_%d.xml" // if r.Options.RecentWindow is set.
+//     r.Options.ServerPath + "sitemap_delta_%d.xml" // if r.Options.Delta is set.
+//     r.Options.ServerPath + "atom.xml" and "rss.xml" // if r.Options.Feed is set.
+//
+// The names of the index and of the sitemaps follow r.Options.IndexFile and r.Options.SitemapPrefix.
+// Requests to these routes for files which don't exist fall through to r.NotFoundHandler, if set.
 func (r *Router) HandleSitemaps() http.Handler {
 	sitemapHandler := r.SitemapHandler()
-	r.Handle(r.Options.ServerPath+`{file:sitemap(index|_\d+)\.xml}`, sitemapHandler)
+	r.handleFiles(sitemapHandler, sitemapRoutes)
 	return sitemapHandler
 }
 
-// SitemapHandler creates and returns a new http.Handler for sitemaps. It expects to serve r,Options.ServerPath + `{file:sitemap(index|_\d+)\.xml}`.
+// sitemapRoutes returns the routes of HandleSitemaps() with the options o.
+func sitemapRoutes(o *Options) []string {
+	return []string{sitemapRoute(o), feed_route}
+}
+
+// handleFiles registers handler on r.Options.ServerPath + each of routes(r.Options),
+// restricted to the host of r.Options.Domain if r.Options.StrictHost is set.
+func (r *Router) handleFiles(handler http.Handler, routes func(o *Options) []string) {
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+	r.files = append(r.files, r.registerFiles(r.options(), handler, routes))
+}
+
+// fileRoutes are routes registered by handleFiles.
+type fileRoutes struct {
+	serverPath string
+	handler    http.Handler
+	routes     func(o *Options) []string
+	patterns   []string // routes registered, without the server path
+	disabled   int32    // set atomically when registered again with other routes
+}
+
+// registerFiles registers handler on routes(opts) with the options opts. The caller must hold the routes lock.
+func (r *Router) registerFiles(opts *Options, handler http.Handler, routes func(o *Options) []string) *fileRoutes {
+	f := &fileRoutes{
+		serverPath: opts.serverPath(),
+		handler:    handler,
+		routes:     routes,
+		patterns:   routes(opts),
+	}
+	enabled := func(*http.Request, *mux.RouteMatch) bool {
+		return atomic.LoadInt32(&f.disabled) == 0
+	}
+	for _, route := range f.patterns {
+		r.secretRoutes = append(r.secretRoutes, f.serverPath+route)
+		muxRoute := r.Handle(f.serverPath+route, handler).MatcherFunc(enabled)
+		if opts.StrictHost && opts.Domain != "" {
+			muxRoute.Host(domainHost(opts.Domain))
+		}
+	}
+	return f
+}
+
+// notFound replies to requests for sitemap files which don't exist with the NotFoundHandler of the embedded mux.Router,
+// as if the routes of HandleSitemaps() (and the like) didn't match, or with http.NotFound if it isn't set.
+func (r *Router) notFound(w http.ResponseWriter, req *http.Request) {
+	if r.Router.NotFoundHandler == nil {
+		http.NotFound(w, req)
+		return
+	}
+	r.Router.NotFoundHandler.ServeHTTP(w, req)
+}
+
+// ServeHTTP dispatches the request to the embedded mux.Router.
+// Before the first request, the routes of HandleSitemaps() (and the like) are registered again
+// if r.Options.ServerPath, IndexFile or SitemapPrefix changed since their registration. They can't change afterwards, see UpdateOptions().
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.serving.Do(r.updateFileRoutes)
+	r.Router.ServeHTTP(w, req)
+}
+
+// updateFileRoutes registers the file routes again if they changed with the current options.
+func (r *Router) updateFileRoutes() {
+	r.optionsMutex.Lock()
+	atomic.StoreInt32(&r.served, 1)
+	opts := r.Options
+	r.optionsMutex.Unlock()
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+	for i, f := range r.files {
+		if f.serverPath != opts.serverPath() || !reflect.DeepEqual(f.patterns, f.routes(opts)) {
+			atomic.StoreInt32(&f.disabled, 1)
+			r.files[i] = r.registerFiles(opts, f.handler, f.routes)
+		}
+	}
+}
+
+// serverPath returns o.ServerPath with a leading and a trailing slash.
+func (o *Options) serverPath() string {
+	p, _ := cleanServerPath(o.ServerPath)
+	return p
+}
+
+// cleanServerPath adds the leading and trailing slashes missing from p ("sitemaps" becomes "/sitemaps/"),
+// and fails if p can't be a path prefix (e.g. with a query or a route variable).
+func cleanServerPath(p string) (string, error) {
+	if strings.ContainsAny(p, "?#{} ") || strings.Contains(p, "//") {
+		return p, fmt.Errorf("sitemap: invalid server path %q", p)
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	return p, nil
+}
+
+const (
+	index_file             = "sitemapindex.xml"
+	default_sitemap_prefix = "sitemap"
+)
+
+// indexFile returns o.IndexFile, or the default name of the index.
+func (o *Options) indexFile() string {
+	if o.IndexFile == "" {
+		return index_file
+	}
+	return o.IndexFile
+}
+
+// sitemapPrefix returns o.SitemapPrefix, or the default prefix of the sitemaps.
+func (o *Options) sitemapPrefix() string {
+	if o.SitemapPrefix == "" {
+		return default_sitemap_prefix
+	}
+	return o.SitemapPrefix
+}
+
+// sitemapRoute returns the route matching the names of all the sitemap files generated with o.
+func sitemapRoute(o *Options) string {
+	return `{file:(?:` + regexp.QuoteMeta(o.indexFile()) + `|` + regexp.QuoteMeta(single_sitemap_file) + `|` +
+		regexp.QuoteMeta(o.sitemapPrefix()) + `(?:_[a-z0-9.-]+)?_[0-9a-f]+\.(?:xml(?:\.gz)?|txt))}`
+}
+
+// feed_route matches the names of the feeds.
+const feed_route = `{file:(?:atom|rss)\.xml}`
+
+// SitemapHandler creates and returns a new http.Handler for sitemaps. It expects to serve r,Options.ServerPath + sitemapRoute(r.Options).
 func (r *Router) SitemapHandler() http.Handler {
 	return &sitemapHandler{
 		router: r,
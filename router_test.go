@@ -1,13 +1,25 @@
 package sitemap
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -200,31 +212,1731 @@ func TestPackageDocumentation(t *testing.T) {
 	t.Logf("%d - %s", w.Code, w.Body.String())
 }
 
+func TestClock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.Clock = FixedClock(now)
+	r.Register("/")
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := new(SitemapIndex)
+	mustReadXML(filepath.Join(dir, "sitemapindex.xml"), index, t)
+	if len(index.SitemapRefs) != 1 {
+		t.Fatal("Expecting exactly one sitemap")
+	}
+	lastmod := index.SitemapRefs[0].LastModification
+	if lastmod == nil || !lastmod.Equal(now) {
+		t.Errorf("Expecting lastmod %v but got %v", now, lastmod)
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	var outputs [][]byte
+	for _, routes := range [][]string{{"/a", "/b", "/c"}, {"/c", "/a", "/b"}} {
+		dir, err := ioutil.TempDir("", "sitemap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+		r.Options.Reproducible = true
+		for _, route := range routes {
+			r.Register(route)
+		}
+		files, err := r.GenerateSitemaps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			bytes, err := ioutil.ReadFile(filepath.Join(dir, f))
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs = append(outputs, bytes)
+		}
+	}
+	if len(outputs)%2 != 0 {
+		t.Fatalf("Expecting the same number of files but got %d in total", len(outputs))
+	}
+	n := len(outputs) / 2
+	for i := 0; i < n; i++ {
+		if string(outputs[i]) != string(outputs[i+n]) {
+			t.Errorf("Generations differ:\n%s\n%s", outputs[i], outputs[i+n])
+		}
+	}
+}
+
+func TestHashFileNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "", dir)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	r.Options.Domain = ts.URL
+	r.Options.HashFileNames = true
+	r.Register("/")
+	r.HandleSitemaps()
+
+	index := new(SitemapIndex)
+	mustGetXML(ts.URL+"/sitemapindex.xml", index, t)
+	if len(index.SitemapRefs) != 1 {
+		t.Fatal("Expecting exactly one sitemap")
+	}
+
+	loc := index.SitemapRefs[0].Location
+	if !regexp.MustCompile(`/sitemap_[0-9a-f]{16}\.xml$`).MatchString(loc) {
+		t.Errorf("Sitemap %s is not named after its hash", loc)
+	}
+
+	sm := new(Sitemap)
+	mustGetXML(loc, sm, t)
+	if len(sm.Entries) != 1 {
+		t.Errorf("Expecting 1 but got %d urls in sitemap", len(sm.Entries))
+	}
+}
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/a")
+	r.Register("/b")
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := r.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 2 {
+		t.Fatalf("Expecting 2 files in manifest but got %d", len(m.Files))
+	}
+	for _, f := range m.Files {
+		info, err := os.Stat(filepath.Join(dir, f.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != f.Size {
+			t.Errorf("%s: expecting size %d but manifest says %d", f.Name, info.Size(), f.Size)
+		}
+	}
+	if m.Files[0].URLs != 2 {
+		t.Errorf("Expecting 2 urls in %s but got %d", m.Files[0].Name, m.Files[0].URLs)
+	}
+}
+
+func TestUpdateOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "", dir)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	r.Options.Domain = ts.URL
+	r.Register("/")
+	r.HandleSitemaps()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			mustGetXML(ts.URL+"/sitemapindex.xml", new(SitemapIndex), t)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			r.UpdateOptions(func(o *Options) {
+				o.DefaultPriority = float64(i) / 10
+			})
+			_, err := r.GenerateSitemaps()
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestGenerationQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	var generations int32
+	release := make(chan struct{})
+	r.RegisterParam("/documents/{id}", func(cb func(...string) error) error {
+		if atomic.AddInt32(&generations, 1) == 1 {
+			<-release
+		}
+		return cb("id", "1")
+	})
+
+	var wg sync.WaitGroup
+	generate := func() {
+		defer wg.Done()
+		_, err := r.GenerateSitemaps()
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Add(1)
+	go generate()
+	for !r.Queue().Running {
+		time.Sleep(time.Millisecond)
+	}
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go generate()
+	}
+	for r.Queue().Waiting != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if generations != 2 {
+		t.Errorf("The waiting calls should share one generation, got %d generations", generations)
+	}
+	if queue := r.Queue(); queue.Running || queue.Waiting != 0 {
+		t.Errorf("Expecting an empty queue, got %+v", queue)
+	}
+}
+
+func TestGenerationQueueOverlap(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Options.Storage = NewMemoryStorage()
+	var generations int32
+	releases := []chan struct{}{make(chan struct{}), make(chan struct{})}
+	r.RegisterParam("/documents/{id}", func(cb func(...string) error) error {
+		if n := atomic.AddInt32(&generations, 1); int(n) <= len(releases) {
+			<-releases[n-1]
+		}
+		return cb("id", "1")
+	})
+
+	var wg sync.WaitGroup
+	generate := func(calls int) {
+		wg.Add(calls)
+		for i := 0; i < calls; i++ {
+			go func() {
+				defer wg.Done()
+				_, err := r.GenerateSitemaps()
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+	}
+	waitQueue := func(generation int32, waiting int) {
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			queue := r.Queue()
+			if queue.Running && atomic.LoadInt32(&generations) == generation && queue.Waiting == waiting {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expecting generation %d with %d waiting calls, got generation %d and %+v", generation, waiting, atomic.LoadInt32(&generations), queue)
+			}
+		}
+	}
+
+	generate(1)
+	waitQueue(1, 0)
+	generate(2) // both wait for the end of the first generation, then share the second one
+	waitQueue(1, 2)
+	close(releases[0])
+	waitQueue(2, 1) // one call runs the second generation, the other one waits for it
+	generate(1)     // waits for the end of the second generation, then runs the third one
+	waitQueue(2, 2)
+	close(releases[1])
+	wg.Wait()
+	if generations != 3 {
+		t.Errorf("Expecting 3 generations, got %d", generations)
+	}
+	if queue := r.Queue(); queue.Running || queue.Waiting != 0 || queue.ETA != 0 {
+		t.Errorf("Expecting an empty queue, got %+v", queue)
+	}
+}
+
+func TestFileNames(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "", "unused")
+	r.Options.Storage = NewMemoryStorage()
+	r.Options.IndexFile = "sitemap.xml"
+	r.Options.SitemapPrefix = "pages"
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	r.Options.Domain = ts.URL
+	r.Register("/")
+	r.HandleSitemaps()
+
+	index := new(SitemapIndex)
+	mustGetXML(ts.URL+"/sitemap.xml", index, t)
+	if len(index.SitemapRefs) != 1 || index.SitemapRefs[0].Location != ts.URL+"/pages_1.xml" {
+		t.Fatalf("Expecting a reference to %s/pages_1.xml, got %v", ts.URL, index.SitemapRefs)
+	}
+	mustGetXML(index.SitemapRefs[0].Location, new(Sitemap), t)
+	if _, found, err := r.FindURL(ts.URL + "/"); err != nil || !found {
+		t.Errorf("%s/ should be found in the sitemaps (%v)", ts.URL, err)
+	}
+
+	r.UpdateOptions(func(o *Options) {
+		o.SingleSitemap = true
+	})
+	_, err := r.GenerateSitemaps()
+	if err == nil {
+		t.Error("The index should not be named sitemap.xml with SingleSitemap")
+	}
+}
+
+func TestServerPath(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "", "unused")
+	r.Options.Storage = NewMemoryStorage()
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+	r.Options.Domain = ts.URL
+	r.Register("/")
+	r.HandleSitemaps()
+	r.Options.ServerPath = "sitemaps" // changed after HandleSitemaps, before serving
+
+	index := new(SitemapIndex)
+	mustGetXML(ts.URL+"/sitemaps/sitemapindex.xml", index, t)
+	if len(index.SitemapRefs) != 1 || index.SitemapRefs[0].Location != ts.URL+"/sitemaps/sitemap_1.xml" {
+		t.Fatalf("Expecting a reference to %s/sitemaps/sitemap_1.xml, got %v", ts.URL, index.SitemapRefs)
+	}
+	mustGetXML(index.SitemapRefs[0].Location, new(Sitemap), t)
+	resp, err := http.Get(ts.URL + "/sitemapindex.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("The previous server path should not be served, got %s", resp.Status)
+	}
+
+	for _, update := range []func(o *Options){
+		func(o *Options) { o.ServerPath = "/other/" },
+		func(o *Options) { o.IndexFile = "index.xml" },
+		func(o *Options) { o.SitemapPrefix = "urls" },
+	} {
+		if err := r.UpdateOptions(update); err != ErrRoutesChanged {
+			t.Errorf("Expecting %v once served but got %v", ErrRoutesChanged, err)
+		}
+	}
+	if r.Options.serverPath() != "/sitemaps/" || r.Options.indexFile() != index_file {
+		t.Errorf("Rejected updates should keep the options, got %+v", r.Options)
+	}
+	mustGetXML(ts.URL+"/sitemaps/sitemapindex.xml", new(SitemapIndex), t)
+
+	unserved := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	unserved.Options.Storage = NewMemoryStorage()
+	unserved.Register("/")
+	unserved.HandleSitemaps()
+	for _, invalid := range []string{"/sitemaps?a=1", "/{lang}/", "//"} {
+		unserved.UpdateOptions(func(o *Options) {
+			o.ServerPath = invalid
+		})
+		_, err := unserved.GenerateSitemaps()
+		if err == nil {
+			t.Errorf("Server path %q should be rejected", invalid)
+		}
+	}
+}
+
+func TestFileNamesBeforeServing(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Options.Storage = NewMemoryStorage()
+	r.Register("/")
+	r.HandleSitemaps()
+	r.UpdateOptions(func(o *Options) {
+		o.IndexFile = "index.xml"
+		o.SitemapPrefix = "urls"
+	})
+
+	index := new(SitemapIndex)
+	mustServeXML(r, "http://example.com/index.xml", index, t)
+	if len(index.SitemapRefs) != 1 || index.SitemapRefs[0].Location != "http://example.com/urls_1.xml" {
+		t.Fatalf("Expecting a reference to http://example.com/urls_1.xml, got %v", index.SitemapRefs)
+	}
+	mustServeXML(r, index.SitemapRefs[0].Location, new(Sitemap), t)
+}
+
+func TestCoverage(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Register("/about")
+	r.RegisterSecret("/admin")
+	r.HandleFunc("/forgotten", func(w http.ResponseWriter, r *http.Request) {})
+	r.RegisterParam("/documents/{id}", func(cb func(...string) error) error { return nil })
+	r.HandleSitemaps()
+
+	coverage, err := r.Coverage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RouteCoverage{
+		{Pattern: "/about", Status: Listed},
+		{Pattern: "/admin", Status: Secret},
+		{Pattern: "/forgotten", Status: Unlisted},
+		{Pattern: "/documents/{id}", Status: Listed},
+		{Pattern: "/" + sitemapRoute(r.Options), Status: Secret},
+		{Pattern: "/" + feed_route, Status: Secret},
+	}
+	if !reflect.DeepEqual(coverage, expected) {
+		t.Errorf("Expecting %v but got %v", expected, coverage)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.RegisterParam("/documents/{id}", func(cb func(...string) error) error { return nil })
+	r.Register("/about")
+	r.HandleFunc("/secret", func(w http.ResponseWriter, r *http.Request) {})
+
+	routes := r.Routes()
+	expected := []RouteInfo{
+		{Pattern: "/about", Priority: 0.5},
+		{Pattern: "/documents/{id}", Parameterized: true, Priority: 0.5},
+	}
+	if len(routes) != len(expected) {
+		t.Fatalf("Expecting %v but got %v", expected, routes)
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(routes[i], expected[i]) {
+			t.Errorf("Expecting %v but got %v", expected[i], routes[i])
+		}
+	}
+}
+
+func TestLearnLastModified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lastModified := time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)
+	now := lastModified.Add(time.Hour)
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.Clock = FixedClock(now)
+	r.Use(r.LearnLastModified)
+	r.Register("/static").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	})
+	etag := "v1"
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		return cb("id", "a")
+	}).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("Expecting the response writer to be an http.Flusher")
+		}
+		w.Header().Set("ETag", etag)
+	})
+
+	get := func(path string) {
+		req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	get("/doc/a") // not enumerated yet: ignored
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	get("/static")
+	get("/doc/a")
+	etag = "v2"
+	get("/doc/a")
+	get("/doc/b") // never enumerated: ignored
+	if _, ok := r.lastMods.etags["/doc/b"]; ok {
+		t.Errorf("Expecting no ETag learned for a page out of the sitemap")
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	expected := map[string]time.Time{
+		"http://example.com/static": lastModified,
+		"http://example.com/doc/a":  now,
+	}
+	for _, e := range sm.Entries {
+		if e.LastModification == nil || !e.LastModification.Equal(expected[e.Location]) {
+			t.Errorf("%s: expecting lastmod %v but got %v", e.Location, expected[e.Location], e.LastModification)
+		}
+	}
+}
+
+func TestPopularity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Use(r.CountAccesses)
+	r.Options.Popularity = &Popularity{Count: r.AccessCount, Min: 0.1, Max: 0.9}
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	r.Register("/popular").HandlerFunc(handler)
+	r.Register("/visited").HandlerFunc(handler)
+	r.Register("/forgotten").HandlerFunc(handler)
+
+	for path, n := range map[string]int{"/popular": 100, "/visited": 3} {
+		for i := 0; i < n; i++ {
+			req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	priorities := make(map[string]float64)
+	for _, e := range sm.Entries {
+		priorities[e.Location] = *e.Priority
+	}
+	popular, visited, forgotten := priorities["http://example.com/popular"], priorities["http://example.com/visited"], priorities["http://example.com/forgotten"]
+	if popular != 0.9 || forgotten != 0.1 || visited <= forgotten || visited >= popular {
+		t.Errorf("Unexpected priorities %v", priorities)
+	}
+}
+
+func TestServeStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	var sourceErr error
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		if sourceErr != nil {
+			return sourceErr
+		}
+		return cb("id", "a")
+	})
+	handler := r.HandleSitemaps()
+
+	sourceErr = errors.New("database is down")
+	req, err := http.NewRequest("GET", "http://example.com/sitemapindex.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting 503 without sitemaps but got %d", w.Code)
+	}
+
+	sourceErr = nil
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceErr = errors.New("database is down")
+	_, err = r.GenerateSitemaps()
+	if err != sourceErr {
+		t.Fatalf("Expecting error %v but got %v", sourceErr, err)
+	}
+	if stats := r.Stats(); !stats.Stale() || stats.LastError != sourceErr {
+		t.Errorf("Stats should report stale sitemaps: %+v", stats)
+	}
+
+	index := new(SitemapIndex)
+	mustServeXML(handler, "http://example.com/sitemapindex.xml", index, t)
+	sm := new(Sitemap)
+	mustServeXML(handler, index.SitemapRefs[0].Location, sm, t)
+	if len(sm.Entries) != 1 {
+		t.Errorf("Expecting the previous sitemap to be served")
+	}
+}
+
+func TestServeStaleSitemaps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.Resume = true // writes the sitemaps of each tag before enumerating the next one
+	doc := "a"
+	var sourceErr error
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		return cb("id", doc)
+	})
+	r.RegisterParam("/news/{id}", func(cb func(...string) error) error {
+		if sourceErr != nil {
+			return sourceErr
+		}
+		return cb("id", "x")
+	})
+	for _, tag := range []string{"doc", "news"} {
+		err = r.Tag("/"+tag+"/{id}", tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := r.HandleSitemaps()
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, sourceErr = "b", errors.New("database is down")
+	_, err = r.GenerateSitemaps()
+	if err != sourceErr {
+		t.Fatalf("Expecting error %v but got %v", sourceErr, err)
+	}
+
+	index := new(SitemapIndex)
+	mustServeXML(handler, "http://example.com/sitemapindex.xml", index, t)
+	var locations []string
+	for _, ref := range index.SitemapRefs {
+		sm := new(Sitemap)
+		mustServeXML(handler, ref.Location, sm, t)
+		for _, e := range sm.Entries {
+			locations = append(locations, e.Location)
+		}
+	}
+	sort.Strings(locations)
+	if expected := []string{"http://example.com/doc/a", "http://example.com/news/x"}; !reflect.DeepEqual(locations, expected) {
+		t.Errorf("Expecting the previous sitemaps %v but got %v", expected, locations)
+	}
+
+	sourceErr = nil // resumes with the staged sitemaps of the doc tag
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := new(Sitemap)
+	mustServeXML(handler, index.SitemapRefs[0].Location, sm, t)
+	if len(sm.Entries) != 1 || sm.Entries[0].Location != "http://example.com/doc/b" {
+		t.Errorf("Expecting the new sitemap but got %+v", sm.Entries)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.ReadOnly = true
+	r.Register("/about")
+	handler := r.HandleSitemaps()
+
+	req, err := http.NewRequest("GET", "http://example.com/sitemapindex.xml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expecting 503 without sitemaps but got %d", w.Code)
+	}
+	if generations := r.Stats().Generations; generations != 0 {
+		t.Errorf("Read-only handler should not generate, got %d generations", generations)
+	}
+
+	// e.g. by a dedicated worker
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustServeXML(handler, "http://example.com/sitemapindex.xml", new(SitemapIndex), t)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.CircuitBreaker = &CircuitBreaker{Failures: 2, Cooldown: time.Hour}
+	calls := 0
+	var sourceErr error
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		calls++
+		if sourceErr != nil {
+			return sourceErr
+		}
+		return cb("id", "a")
+	})
+	r.Register("/static")
+
+	for i := 0; i < 4; i++ {
+		if i == 1 {
+			sourceErr = errors.New("database is down")
+		}
+		_, err = r.GenerateSitemaps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := new(Sitemap)
+		mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+		if len(sm.Entries) != 2 {
+			t.Errorf("Generation %d: expecting 2 entries but got %d", i, len(sm.Entries))
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Expecting the route to be skipped after 2 failures, but got %d calls", calls)
+	}
+	if open := r.OpenCircuits(); len(open) != 1 || open[0] != "/doc/{id}" {
+		t.Errorf("Unexpected open circuits %v", open)
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			r.Register(fmt.Sprintf("/page/%d", i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := r.GenerateSitemaps()
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(r.Routes()); n != 10 {
+		t.Errorf("Expecting 10 routes but got %d", n)
+	}
+}
+
+func TestUnicodeLocations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/über")
+	r.RegisterParam("/produits/{name}", func(cb func(...string) error) error {
+		return cb("name", "crème & brûlée")
+	})
+	r.RegisterParam("/商品/{id}", func(cb func(...string) error) error {
+		return cb("id", "茶")
+	})
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	set := getLocationSet(sm.Entries)
+	for _, loc := range []string{
+		"http://example.com/%C3%BCber",
+		"http://example.com/produits/cr%C3%A8me%20&%20br%C3%BBl%C3%A9e",
+		"http://example.com/%E5%95%86%E5%93%81/%E8%8C%B6",
+	} {
+		if _, ok := set[loc]; !ok {
+			t.Errorf("Expecting %s in %v", loc, set)
+		}
+	}
+}
+
 func getBytes(addr string) ([]byte, error) {
 	res, err := http.Get(addr)
 	if err != nil {
-		return nil, err
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func mustGetXML(addr string, v interface{}, t *testing.T) {
+	bytes, err := getBytes(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xml.Unmarshal(bytes, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustReadXML(path string, v interface{}, t *testing.T) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = xml.Unmarshal(bytes, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getLocationSet(entries []*Entry) map[string]struct{} {
+	m := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		m[e.Location] = struct{}{}
+	}
+	return m
+}
+
+func TestWarmup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.Clock = FixedClock(now)
+	r.Options.RefreshInterval = time.Hour
+	r.Register("/about")
+
+	for i, expected := range []int{1, 1} {
+		err = r.Warmup(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generations := r.Stats().Generations; generations != expected {
+			t.Errorf("Expecting %d generations after warmup %d but got %d", expected, i, generations)
+		}
+	}
+
+	r.UpdateOptions(func(o *Options) {
+		o.Clock = FixedClock(now.Add(2 * time.Hour))
+	})
+	err = r.Warmup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generations := r.Stats().Generations; generations != 2 {
+		t.Errorf("Stale sitemaps should be generated again, got %d generations", generations)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about")
+	var notified *Manifest
+	r.Options.Notifier = NotifierFunc(func(m *Manifest, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		if !r.sitemapMutex.TryRLock() {
+			t.Error("Expecting the sitemaps to be unlocked while notifying")
+		} else {
+			r.sitemapMutex.RUnlock()
+		}
+		notified = m
+	})
+
+	var g Generator = r
+	_, err = g.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notified == nil || notified.URLs != 1 {
+		t.Errorf("Unexpected notification %v", notified)
+	}
+}
+
+func TestSingleSitemap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.SingleSitemap = true
+	r.Options.Compression = DefaultCompression
+	r.Register("/about")
+	r.Register("/contact")
+	handler := r.HandleSitemaps()
+
+	sm := new(Sitemap)
+	mustServeXML(handler, "http://example.com/sitemap.xml", sm, t)
+	if len(sm.Entries) != 2 {
+		t.Errorf("Expecting 2 entries in sitemap.xml but got %d", len(sm.Entries))
+	}
+	if _, found, err := r.FindURL("http://example.com/about"); err != nil || !found {
+		t.Errorf("FindURL should find /about once: %v", err)
+	}
+
+	r.Tag("/contact", "en")
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := new(SitemapIndex)
+	mustServeXML(handler, "http://example.com/sitemap.xml", index, t)
+	if len(index.SitemapRefs) != 2 {
+		t.Errorf("sitemap.xml should be the index of 2 sitemaps, got %v", index.SitemapRefs)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Register("/about")
+	r.Register("/about")
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error { return nil })
+	r.Register("/doc/{id}")
+	r.Register("/contact")
+
+	if routes := r.Routes(); len(routes) != 3 {
+		t.Errorf("Duplicates should not be registered, got %v", routes)
+	}
+	conflicts := r.Conflicts()
+	if len(conflicts) != 2 {
+		t.Fatalf("Expecting 2 conflicts, got %v", conflicts)
+	}
+	expected := `sitemap: Register("/doc/{id}") ignored, the pattern is already registered with RegisterParam`
+	if conflicts[1].Error() != expected {
+		t.Errorf("Expecting %q but got %q", expected, conflicts[1])
+	}
+}
+
+func TestTracingHeaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := &testClock{time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)}
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Options.Clock = clock
+	r.Options.TracingHeaders = true
+	r.Register("/about")
+	handler := r.HandleSitemaps()
+
+	serve := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "http://example.com/sitemapindex.xml", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expecting 200 but got %d", w.Code)
+		}
+		return w
+	}
+
+	w := serve()
+	if timing := w.Header().Get("Server-Timing"); timing != `cache;desc="miss", gen;dur=0, age;dur=0` {
+		t.Errorf("Unexpected Server-Timing on the first request: %q", timing)
+	}
+	if trace := w.Header().Get("Traceresponse"); trace != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Expecting the trace context to be echoed, got %q", trace)
+	}
+
+	clock.now = clock.now.Add(1500 * time.Millisecond)
+	if timing := serve().Header().Get("Server-Timing"); timing != `cache;desc="hit", age;dur=1500` {
+		t.Errorf("Unexpected Server-Timing on the second request: %q", timing)
+	}
+}
+
+func TestNotFoundFallthrough(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "custom not found", http.StatusNotFound)
+	})
+	r.Register("/about")
+	r.HandleSitemaps()
+
+	for _, path := range []string{"/sitemap_7.xml", "/unknown.xml"} {
+		req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "custom not found") {
+			t.Errorf("Expecting the custom 404 on %s, got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestXMLHeader(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		omit   bool
+		prefix string
+	}{
+		{"", false, xml.Header + "<urlset"},
+		{StandaloneXMLHeader, false, StandaloneXMLHeader + "<urlset"},
+		{StandaloneXMLHeader, true, "<urlset"},
+	} {
+		storage := NewMemoryStorage()
+		r := NewRouter(mux.NewRouter(), "http://example.com", "")
+		r.Options.Storage = storage
+		r.Options.XMLHeader = test.header
+		r.Options.OmitXMLHeader = test.omit
+		r.Register("/about")
+		_, err := r.GenerateSitemaps()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := readFile(storage, "sitemap_1.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), test.prefix) {
+			t.Errorf("Expecting the sitemap to start with %q, got %q", test.prefix, data)
+		}
+		index, err := readFile(storage, "sitemapindex.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := strings.Replace(test.prefix, "<urlset", "<sitemapindex", 1); !strings.HasPrefix(string(index), expected) {
+			t.Errorf("Expecting the index to start with %q, got %q", expected, index)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = storage
+	r.Register("/about")
+
+	blog := NewRouter(mux.NewRouter(), "http://example.com", "")
+	blog.Register("/")
+	blog.RegisterParam("/posts/{id}", func(cb func(...string) error) error {
+		return cb("id", "hello")
+	})
+	if err := r.Mount("blog", blog); err == nil {
+		t.Error("Mount should fail without a leading slash")
+	}
+	if err := r.Mount("/blog/", blog); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Tag("/blog/posts/{id}", "posts"); err != nil {
+		t.Errorf("Mounted routes should be registered under the prefix: %v", err)
+	}
+
+	_, err := r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var locations []string
+	for _, name := range []string{"sitemap_1.xml", "sitemap_posts_1.xml"} {
+		data, err := readFile(storage, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := new(Sitemap)
+		if err := xml.Unmarshal(data, sm); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range sm.Entries {
+			locations = append(locations, e.Location)
+		}
+	}
+	expected := []string{"http://example.com/about", "http://example.com/blog/", "http://example.com/blog/posts/hello"}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("Expecting %v but got %v", expected, locations)
+	}
+}
+
+func TestOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about")
+	r.RegisterParam("/products/{id}", func(cb func(...string) error) error {
+		return cb("id", "1")
+	})
+	r.Register("/contact")
+	if err := r.SetOrder("/products/{id}", -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetOrder("/about", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var patterns []string
+	for _, route := range r.Routes() {
+		patterns = append(patterns, route.Pattern)
+	}
+	expected := []string{"/products/{id}", "/contact", "/about"}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("Expecting the routes %v but got %v", expected, patterns)
+	}
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	var locations []string
+	for _, e := range sm.Entries {
+		locations = append(locations, e.Location)
+	}
+	expected = []string{"http://example.com/products/1", "http://example.com/contact", "http://example.com/about"}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("Expecting the entries %v but got %v", expected, locations)
+	}
+}
+
+func TestSetRouteEnabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about")
+	r.RegisterParam("/products/{id}", func(cb func(...string) error) error {
+		return cb("id", "1")
+	})
+	if err := r.SetRouteEnabled("/unknown", false); err == nil {
+		t.Error("SetRouteEnabled should fail on unknown routes")
+	}
+
+	generate := func() int {
+		_, err := r.GenerateSitemaps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Stats().URLs
+	}
+	if err := r.SetRouteEnabled("/products/{id}", false); err != nil {
+		t.Fatal(err)
+	}
+	if urls := generate(); urls != 1 {
+		t.Errorf("Expecting 1 url with the disabled route, got %d", urls)
+	}
+	if routes := r.Routes(); len(routes) != 2 || !routes[1].Disabled {
+		t.Errorf("Expecting the disabled route in Routes(), got %v", routes)
+	}
+	if err := r.SetRouteEnabled("/products/{id}", true); err != nil {
+		t.Fatal(err)
+	}
+	if urls := generate(); urls != 2 {
+		t.Errorf("Expecting 2 urls once the route is enabled again, got %d", urls)
 	}
-	defer res.Body.Close()
-	return ioutil.ReadAll(res.Body)
 }
 
-func mustGetXML(addr string, v interface{}, t *testing.T) {
-	bytes, err := getBytes(addr)
+// testTimer is a Timer whose time is advanced by tests.
+type testTimer struct {
+	testClock
+	funcs []func()
+	due   []time.Time
+}
+
+func (c *testTimer) AfterFunc(d time.Duration, f func()) {
+	c.funcs = append(c.funcs, f)
+	c.due = append(c.due, c.now.Add(d))
+}
+
+// advance moves the time forward by d, and calls the functions due.
+func (c *testTimer) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	for i := 0; i < len(c.funcs); i++ {
+		if f := c.funcs[i]; f != nil && !c.due[i].After(c.now) {
+			c.funcs[i] = nil
+			f()
+		}
+	}
+}
+
+func TestThrottleNotifier(t *testing.T) {
+	clock := &testTimer{testClock: testClock{time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)}}
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = NewMemoryStorage()
+	r.Options.Clock = clock
+	var notified []int
+	r.Options.Notifier = ThrottleNotifier(NotifierFunc(func(m *Manifest, err error) {
+		notified = append(notified, m.URLs)
+	}), time.Minute)
+
+	for urls := 1; urls <= 3; urls++ {
+		r.Register(fmt.Sprintf("/page%d", urls))
+		if _, err := r.GenerateSitemaps(); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Second)
+	}
+	if len(notified) != 1 || notified[0] != 1 {
+		t.Errorf("Expecting the first generation to be notified right away, got %v", notified)
+	}
+	clock.advance(time.Minute)
+	if len(notified) != 2 || notified[1] != 3 {
+		t.Errorf("Expecting the last generation to be notified after the interval, got %v", notified)
+	}
+	clock.advance(time.Hour)
+	if len(notified) != 2 {
+		t.Errorf("Unexpected notifications %v", notified)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
 
-	err = xml.Unmarshal(bytes, v)
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about").Priority(0.8).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r.RegisterParam("/products/{id}", func(cb func(...string) error) error {
+		return cb("id", "1")
+	}).Priority(0.3)
+	if err := r.Register("/contact").Priority(1.5).Err(); err == nil {
+		t.Error("Expecting the invalid priority of the route to be reported")
+	}
+	if err := r.SetPriority("/about", -1); err == nil {
+		t.Error("SetPriority should fail on priorities outside [0, 1]")
+	}
+	_, err = r.GenerateSitemaps()
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	expected := map[string]float64{
+		"http://example.com/about":      0.8,
+		"http://example.com/contact":    0.5,
+		"http://example.com/products/1": 0.3,
+	}
+	if len(sm.Entries) != len(expected) {
+		t.Fatalf("Expecting %d entries, got %d", len(expected), len(sm.Entries))
+	}
+	for _, e := range sm.Entries {
+		if e.Priority == nil || *e.Priority != expected[e.Location] {
+			t.Errorf("Expecting priority %v for %s, got %v", expected[e.Location], e.Location, e.Priority)
+		}
+	}
 }
 
-func getLocationSet(entries []*Entry) map[string]struct{} {
-	m := make(map[string]struct{}, len(entries))
-	for _, e := range entries {
-		m[e.Location] = struct{}{}
+func TestRouteChangeFrequency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about").ChangeFrequency(Yearly)
+	r.RegisterParam("/news/{id}", func(cb func(...string) error) error {
+		return cb("id", "1")
+	}).ChangeFrequency(Daily)
+	r.Register("/contact")
+	if err := r.SetChangeFrequency("/contact", "sometimes"); err == nil {
+		t.Error("SetChangeFrequency should fail on invalid change frequencies")
+	}
+	if err := r.Register("/help").ChangeFrequency("sometimes").Err(); err == nil {
+		t.Error("Expecting the invalid change frequency of the route to be reported")
+	}
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	expected := map[string]ChangeFrequency{
+		"http://example.com/about":   Yearly,
+		"http://example.com/contact": "",
+		"http://example.com/news/1":  Daily,
+	}
+	for _, e := range sm.Entries {
+		if e.ChangeFrequency != expected[e.Location] {
+			t.Errorf("Expecting change frequency %q for %s, got %q", expected[e.Location], e.Location, e.ChangeFrequency)
+		}
+	}
+}
+
+func TestRegisterParamAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lastmod := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	priority := 0.9
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.RegisterParamAttributes("/news/{id}", func(cb func(Attributes, ...string) error) error {
+		err := cb(Attributes{LastModification: &lastmod, Priority: &priority, ChangeFrequency: Daily}, "id", "1")
+		if err != nil {
+			return err
+		}
+		return cb(Attributes{}, "id", "2")
+	})
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 2 {
+		t.Fatalf("Expecting 2 entries, got %d", len(sm.Entries))
+	}
+	first, second := sm.Entries[0], sm.Entries[1]
+	if first.LastModification == nil || !first.LastModification.Equal(lastmod) || *first.Priority != 0.9 || first.ChangeFrequency != Daily {
+		t.Errorf("Expecting the attributes of the enumerator, got %+v", first)
+	}
+	if second.LastModification != nil || *second.Priority != 0.5 || second.ChangeFrequency != "" {
+		t.Errorf("Expecting the attributes of the route, got %+v", second)
+	}
+}
+
+func TestRegisterParamEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lastmod := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.RegisterParamEntries("/news/{id}", func(emit func([]string, *Entry) error) error {
+		err := emit([]string{"id", "1"}, &Entry{
+			FileReference:   &FileReference{Location: "ignored", LastModification: &lastmod},
+			ChangeFrequency: Weekly,
+			Alternates:      []*Alternate{HreflangAlternate("fr", "http://example.com/fr/news/1")},
+		})
+		if err != nil {
+			return err
+		}
+		return emit([]string{"id", "2"}, nil)
+	}).Priority(0.7)
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 2 {
+		t.Fatalf("Expecting 2 entries, got %d", len(sm.Entries))
+	}
+	first, second := sm.Entries[0], sm.Entries[1]
+	if first.Location != "http://example.com/news/1" || first.LastModification == nil || !first.LastModification.Equal(lastmod) ||
+		first.ChangeFrequency != Weekly || *first.Priority != 0.7 {
+		t.Errorf("Expecting the entry of the enumerator with the priority of the route, got %+v", first)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "sitemap_1.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `hreflang="fr" href="http://example.com/fr/news/1"`) {
+		t.Errorf("Expecting the alternate of the enumerator:\n%s", data)
+	}
+	if second.Location != "http://example.com/news/2" || second.LastModification != nil || *second.Priority != 0.7 {
+		t.Errorf("Expecting the attributes of the route, got %+v", second)
+	}
+}
+
+func TestPriorityFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.RegisterParamEntries("/products/{id}", func(emit func([]string, *Entry) error) error {
+		for _, year := range []int{2019, 2020} {
+			id := strconv.Itoa(year)
+			err := emit([]string{"id", id}, &Entry{Metadata: map[string]interface{}{"year": year}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}).PriorityFunc(func(e *Entry) float64 {
+		if e.Metadata["year"] == 2020 {
+			return 0.9
+		}
+		return 0.4
+	})
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 2 || *sm.Entries[0].Priority != 0.4 || *sm.Entries[1].Priority != 0.9 {
+		t.Errorf("Expecting the priorities 0.4 and 0.9, got %v", sm.Entries)
+	}
+}
+
+func TestRouteStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	failure := errors.New("database unavailable")
+	var fail bool
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about")
+	r.RegisterParam("/products/{id}", func(cb func(...string) error) error {
+		if fail {
+			return failure
+		}
+		for _, id := range []string{"1", "2", "3"} {
+			if err := cb("id", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := r.Stats().Routes
+	if len(routes) != 2 || routes[0].Pattern != "/about" || routes[0].URLs != 1 ||
+		routes[1].Pattern != "/products/{id}" || routes[1].URLs != 3 || routes[1].Error != nil {
+		t.Errorf("Unexpected route stats %+v", routes)
+	}
+
+	fail = true
+	if _, err = r.GenerateSitemaps(); err == nil {
+		t.Fatal("The generation should fail")
+	}
+	if routes := r.Stats().Routes; len(routes) != 2 || routes[1].Error != failure || routes[1].URLs != 0 {
+		t.Errorf("Expecting the error of the route in its stats, got %+v", routes)
+	}
+}
+
+func TestMarkModified(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.Register("/about")
+	r.Register("/contact")
+	r.SetURLBuilder("/contact", func(route *mux.Route, pairs ...string) (*url.URL, error) {
+		return url.Parse("/contact-us")
+	})
+	r.RegisterParam("/products/{id}", func(cb func(...string) error) error {
+		return cb("id", "42")
+	})
+	day1, day2 := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, time.June, 2, 0, 0, 0, 0, time.UTC)
+	r.MarkModified("/about", day2)
+	r.MarkModified("/about", day1) // earlier: ignored
+	r.MarkModified("/contact", day1)
+	r.MarkModified("/products/42", day2)
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	expected := map[string]time.Time{
+		"http://example.com/about":       day2,
+		"http://example.com/contact-us":  day1,
+		"http://example.com/products/42": day2,
+	}
+	if len(sm.Entries) != len(expected) {
+		t.Fatalf("Expecting %d entries, got %d", len(expected), len(sm.Entries))
+	}
+	for _, e := range sm.Entries {
+		if e.LastModification == nil || !e.LastModification.Equal(expected[e.Location]) {
+			t.Errorf("Expecting lastmod %v for %s, got %v", expected[e.Location], e.Location, e.LastModification)
+		}
+	}
+}
+
+func TestChangeFreqFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRouter(mux.NewRouter(), "http://example.com", dir)
+	r.RegisterParam("/items/{id}", func(cb func(...string) error) error {
+		for _, id := range []string{"live", "archive"} {
+			if err := cb("id", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).ChangeFreqFunc(func(pairs ...string) ChangeFrequency {
+		if pairs[1] == "live" {
+			return Hourly
+		}
+		return Never
+	})
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm := new(Sitemap)
+	mustReadXML(filepath.Join(dir, "sitemap_1.xml"), sm, t)
+	if len(sm.Entries) != 2 || sm.Entries[0].ChangeFrequency != Hourly || sm.Entries[1].ChangeFrequency != Never {
+		t.Errorf("Expecting the change frequencies hourly and never, got %v", sm.Entries)
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	var events []Event
+	bus := new(EventBus)
+	unsubscribe := bus.Subscribe(func(e Event) {
+		events = append(events, e)
+	})
+
+	failure := errors.New("database is down")
+	var sourceErr error
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = storage
+	r.Options.Events = bus
+	r.Options.RejectInvalid = true
+	r.Register("/about").PriorityFunc(func(e *Entry) float64 {
+		return 2
+	})
+	r.RegisterParam("/items/{id}", func(cb func(...string) error) error {
+		if sourceErr != nil {
+			return sourceErr
+		}
+		return cb("id", "1")
+	})
+	_, err := r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expecting 4 events, got %v", events)
+	}
+	if _, ok := events[0].(GenerationStarted); !ok {
+		t.Errorf("Expecting GenerationStarted first, got %#v", events[0])
+	}
+	if e, ok := events[1].(EntryRejected); !ok || e.Reject.Location != "http://example.com/about" {
+		t.Errorf("Expecting the rejection of /about, got %#v", events[1])
+	}
+	if e, ok := events[2].(ShardWritten); !ok || e.Shard.URLs != 1 {
+		t.Errorf("Expecting the shard of 1 url, got %#v", events[2])
+	}
+	if e, ok := events[3].(GenerationFinished); !ok || e.Err != nil || e.Manifest == nil || e.Manifest.URLs != 1 {
+		t.Errorf("Expecting the successful end of the generation, got %#v", events[3])
+	}
+
+	events = nil
+	sourceErr = failure
+	r.Options.Storage = NewMemoryStorage()
+	w := httptest.NewRecorder()
+	r.SitemapHandler().ServeHTTP(w, httptest.NewRequest("GET", "/sitemapindex.xml", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expecting 503, got %d", w.Code)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expecting 4 events, got %v", events)
+	}
+	if e, ok := events[2].(GenerationFinished); !ok || !errors.Is(e.Err, failure) || e.Manifest != nil {
+		t.Errorf("Expecting the failure of the generation, got %#v", events[2])
+	}
+	if e, ok := events[3].(ServeError); !ok || e.Path != "/sitemapindex.xml" || !errors.Is(e.Err, failure) {
+		t.Errorf("Expecting the serve error, got %#v", events[3])
+	}
+
+	unsubscribe()
+	events = nil
+	r.GenerateSitemaps()
+	if len(events) != 0 {
+		t.Errorf("Expecting no events after unsubscribing, got %v", events)
+	}
+}
+
+func TestValidateEntries(t *testing.T) {
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = storage
+	r.Options.ValidateEntries = true
+	r.Register("/about").PriorityFunc(func(e *Entry) float64 {
+		return 2
+	})
+	r.Register("/contact")
+	r.RegisterParam("/items/{id}", func(cb func(...string) error) error {
+		for _, id := range []string{"1", "2"} {
+			if err := cb("id", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).PriorityFunc(func(e *Entry) float64 {
+		return -1
+	})
+	_, err := r.GenerateSitemaps()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expecting a ValidationError, got %v", err)
+	}
+	expected := []RouteValidation{
+		{Pattern: "/about", Invalid: 1, First: Reject{Location: "http://example.com/about", Reason: "priority 2 outside [0, 1]"}},
+		{Pattern: "/items/{id}", Invalid: 2, First: Reject{Location: "http://example.com/items/1", Reason: "priority -1 outside [0, 1]"}},
+	}
+	if !reflect.DeepEqual(verr.Routes, expected) {
+		t.Errorf("Expecting %v, got %v", expected, verr.Routes)
+	}
+	if fileExists(storage, "sitemapindex.xml") {
+		t.Error("The index should not be written")
+	}
+
+	r.Options.ClampPriority = true
+	_, err = r.GenerateSitemaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readFile(storage, "sitemap_1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<priority>1</priority>") || strings.Count(string(data), "<priority>0</priority>") != 2 {
+		t.Errorf("Expecting the priorities to be clamped:\n%s", data)
+	}
+}
+
+func TestGracePeriodRoutes(t *testing.T) {
+	storage := NewMemoryStorage()
+	r := NewRouter(mux.NewRouter(), "http://example.com", "")
+	r.Options.Storage = storage
+	r.Options.GracePeriod = &GracePeriod{Generations: 2}
+	ids := []string{"1", "2"}
+	r.RegisterParam("/items/{id}", func(cb func(...string) error) error {
+		for _, id := range ids {
+			if err := cb("id", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	r.Register("/about")
+	r.Register("/contact")
+	var onEntry []string
+	r.Options.OnEntry = func(e *Entry) *Entry {
+		onEntry = append(onEntry, e.Location)
+		return e
+	}
+	locations := func() []string {
+		_, err := r.GenerateSitemaps()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := new(Sitemap)
+		data, err := readFile(storage, "sitemap_1.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := xml.Unmarshal(data, sm); err != nil {
+			t.Fatal(err)
+		}
+		var locations []string
+		for _, e := range sm.Entries {
+			locations = append(locations, e.Location)
+		}
+		return locations
+	}
+	locations()
+
+	// item 2 disappears, /about is disabled, /contact is filtered out
+	ids = ids[:1]
+	r.SetRouteEnabled("/about", false)
+	r.AddFilter(func(e *Entry) bool {
+		return e.Location != "http://example.com/contact"
+	})
+	onEntry = nil
+	expected := []string{"http://example.com/items/1", "http://example.com/items/2"}
+	if got := locations(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expecting only the missing item to be retained %v, got %v", expected, got)
+	}
+	if !reflect.DeepEqual(onEntry, expected) {
+		t.Errorf("Retained entries should go through OnEntry, got %v", onEntry)
+	}
+	if stats := r.Stats(); stats.RetainedURLs != 1 {
+		t.Errorf("Expecting 1 retained url, got %d", stats.RetainedURLs)
 	}
-	return m
 }
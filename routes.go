@@ -0,0 +1,373 @@
+package sitemap
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteInfo describes a route registered for the sitemap.
+type RouteInfo struct {
+	Pattern       string  // pattern given at registration
+	Parameterized bool    // true if registered with RegisterParam()
+	Priority      float64 // priority of the entries of the route
+	Tags          []string
+	Order         int  // see SetOrder()
+	Disabled      bool // see SetRouteEnabled()
+}
+
+// Routes returns the routes registered for the sitemap in the order of their entries in the sitemaps:
+// by increasing order (see SetOrder()), then static routes first and parameterized routes, each in registration order.
+//
+// Routes handled without registration (e.g. with r.HandleFunc) are not part of the sitemap and not listed.
+func (r *Router) Routes() []RouteInfo {
+	routes := routeInfos(r.registered())
+	sortRoutes(nil, routes)
+	return routes
+}
+
+// routeInfos describes the given routes.
+func routeInfos(static []*path, params []*paramPath) []RouteInfo {
+	routes := make([]RouteInfo, 0, len(static)+len(params))
+	for _, entry := range static {
+		routes = append(routes, RouteInfo{
+			Pattern:  entry.Location,
+			Priority: entry.Priority,
+			Tags:     entry.Tags,
+			Order:    entry.Order,
+			Disabled: entry.Disabled,
+		})
+	}
+	for _, entry := range params {
+		routes = append(routes, RouteInfo{
+			Pattern:       entry.Pattern,
+			Parameterized: true,
+			Priority:      entry.Priority,
+			Tags:          entry.Tags,
+			Order:         entry.Order,
+			Disabled:      entry.Disabled,
+		})
+	}
+	return routes
+}
+
+// sortRoutes sorts routes by increasing order, along with their sources if not nil, keeping their order otherwise.
+func sortRoutes(sources []Source, routes []RouteInfo) {
+	sort.Stable(routesByOrder{sources, routes})
+}
+
+type routesByOrder struct {
+	sources []Source
+	routes  []RouteInfo
+}
+
+func (s routesByOrder) Len() int           { return len(s.routes) }
+func (s routesByOrder) Less(i, j int) bool { return s.routes[i].Order < s.routes[j].Order }
+func (s routesByOrder) Swap(i, j int) {
+	s.routes[i], s.routes[j] = s.routes[j], s.routes[i]
+	if s.sources != nil {
+		s.sources[i], s.sources[j] = s.sources[j], s.sources[i]
+	}
+}
+
+// routeSettings are the settings of a registered route which can be changed after registration.
+type routeSettings struct {
+	Priority        float64
+	PriorityFunc    PriorityFunc
+	ChangeFrequency ChangeFrequency
+	ChangeFreqFunc  ChangeFreqFunc
+	Tags            []string
+	KeepPathCase    bool
+	Metadata        map[string]interface{} // shared by the entries of the route, never modified
+	URLBuilder      URLBuilder
+	MaxEntries      int
+	Representations []Representation // never modified
+	PathPrefix      string           // prefix of the paths of the urls built from the route, see Mount()
+	Order           int
+	Disabled        bool
+}
+
+// updateRoute calls update on the settings of the route registered with pattern.
+func (r *Router) updateRoute(pattern string, update func(s *routeSettings)) error {
+	r.routesMutex.Lock()
+	defer r.routesMutex.Unlock()
+	// entries are replaced rather than modified, as generations may use them concurrently
+	for i, entry := range r.staticEntries {
+		if entry.Location == pattern {
+			updated := *entry
+			update(&updated.routeSettings)
+			r.staticEntries[i] = &updated
+			return nil
+		}
+	}
+	for i, entry := range r.paramEntries {
+		if entry.Pattern == pattern {
+			updated := *entry
+			update(&updated.routeSettings)
+			r.paramEntries[i] = &updated
+			return nil
+		}
+	}
+	return fmt.Errorf("sitemap: no route registered with pattern %q", pattern)
+}
+
+// SetMetadata sets the metadata key to value on every entry of the route registered with pattern (see Entry.Metadata).
+// The metadata map is shared by the entries of the route: filters and other functions must not modify it.
+func (r *Router) SetMetadata(pattern, key string, value interface{}) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		metadata := make(map[string]interface{}, len(s.Metadata)+1)
+		for k, v := range s.Metadata {
+			metadata[k] = v
+		}
+		metadata[key] = value
+		s.Metadata = metadata
+	})
+}
+
+// SetPriority sets the priority of the entries of the route registered with pattern (Options.DefaultPriority by default).
+// It fails if priority is outside [0, 1].
+func (r *Router) SetPriority(pattern string, priority float64) error {
+	if !(priority >= 0 && priority <= 1) {
+		return fmt.Errorf("sitemap: invalid priority %v", priority)
+	}
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.Priority = priority
+	})
+}
+
+// PriorityFunc computes the priority of an entry of a route, e.g. from its location, its lastmod or its metadata
+// (which RegisterParamEntries() can set from the enumerated item).
+type PriorityFunc func(e *Entry) float64
+
+// SetPriorityFunc sets the function computing the priority of each entry of the route registered with pattern
+// during generations, instead of the priority of the route. Priorities given by the enumerator take precedence.
+func (r *Router) SetPriorityFunc(pattern string, f PriorityFunc) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.PriorityFunc = f
+	})
+}
+
+// priority returns the priority of e, an entry of the route: computed by s.PriorityFunc if set, s.Priority otherwise.
+func (s *routeSettings) priority(e *Entry) *float64 {
+	if s.PriorityFunc == nil {
+		return &s.Priority
+	}
+	p := s.PriorityFunc(e)
+	return &p
+}
+
+// SetChangeFrequency sets the change frequency of the entries of the route registered with pattern (none by default).
+func (r *Router) SetChangeFrequency(pattern string, freq ChangeFrequency) error {
+	if freq != "" && !freq.Valid() {
+		return invalidChangeFrequency(freq)
+	}
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.ChangeFrequency = freq
+	})
+}
+
+// ChangeFreqFunc computes the change frequency of an entry of a route from the variables of its url (none for static routes).
+type ChangeFreqFunc func(pairs ...string) ChangeFrequency
+
+// SetChangeFreqFunc sets the function computing the change frequency of each entry of the route registered with pattern
+// during generations, instead of the change frequency of the route. Change frequencies given by the enumerator take precedence.
+func (r *Router) SetChangeFreqFunc(pattern string, f ChangeFreqFunc) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.ChangeFreqFunc = f
+	})
+}
+
+// changeFrequency returns the change frequency of the entry of the route with the variables pairs:
+// computed by s.ChangeFreqFunc if set, s.ChangeFrequency otherwise.
+func (s *routeSettings) changeFrequency(pairs ...string) ChangeFrequency {
+	if s.ChangeFreqFunc == nil {
+		return s.ChangeFrequency
+	}
+	return s.ChangeFreqFunc(pairs...)
+}
+
+// SitemapRoute is a route registered for the sitemap, returned by Register() and RegisterParam().
+// It embeds the mux.Route, to set its handler and matchers as usual, and sets the settings of the route in the sitemap.
+// The settings are chained: the first one which fails is left unset, and its error is returned by Err().
+type SitemapRoute struct {
+	*mux.Route
+	router  *Router
+	pattern string
+	err     error
+}
+
+// Err returns the error of the first setting of the route which failed, if any.
+func (s *SitemapRoute) Err() error {
+	return s.err
+}
+
+// set records the error of a setting of the route.
+func (s *SitemapRoute) set(err error) *SitemapRoute {
+	if s.err == nil {
+		s.err = err
+	}
+	return s
+}
+
+// Priority sets the priority of the entries of the route, see Router.SetPriority().
+func (s *SitemapRoute) Priority(priority float64) *SitemapRoute {
+	return s.set(s.router.SetPriority(s.pattern, priority))
+}
+
+// PriorityFunc sets the function computing the priority of each entry of the route, see Router.SetPriorityFunc().
+func (s *SitemapRoute) PriorityFunc(f PriorityFunc) *SitemapRoute {
+	return s.set(s.router.SetPriorityFunc(s.pattern, f))
+}
+
+// ChangeFreqFunc sets the function computing the change frequency of each entry of the route, see Router.SetChangeFreqFunc().
+func (s *SitemapRoute) ChangeFreqFunc(f ChangeFreqFunc) *SitemapRoute {
+	return s.set(s.router.SetChangeFreqFunc(s.pattern, f))
+}
+
+// ChangeFrequency sets the change frequency of the entries of the route, see Router.SetChangeFrequency().
+func (s *SitemapRoute) ChangeFrequency(freq ChangeFrequency) *SitemapRoute {
+	return s.set(s.router.SetChangeFrequency(s.pattern, freq))
+}
+
+// SetOrder sets the order of the route registered with pattern (0 by default).
+// Routes are enumerated by increasing order during generations, and routes of the same order in registration order
+// (static routes first), e.g. to list a section first in sitemap_1.xml, the sitemap crawlers fetch most.
+// Routes of distinct shards (see Tag()) still go to distinct sitemaps, in the order of the first route of each shard.
+func (r *Router) SetOrder(pattern string, order int) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.Order = order
+	})
+}
+
+// SetRouteEnabled enables or disables the route registered with pattern (routes are enabled when registered).
+// Generations leave out the entries of disabled routes, e.g. while their data is backfilled,
+// but the route keeps its settings and is still served by the mux.Router.
+func (r *Router) SetRouteEnabled(pattern string, enabled bool) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.Disabled = !enabled
+	})
+}
+
+// enabledRoutes returns the routes of static and params which are not disabled, reusing their arrays.
+func enabledRoutes(static []*path, params []*paramPath) ([]*path, []*paramPath) {
+	enabledStatic := static[:0]
+	for _, entry := range static {
+		if !entry.Disabled {
+			enabledStatic = append(enabledStatic, entry)
+		}
+	}
+	enabledParams := params[:0]
+	for _, entry := range params {
+		if !entry.Disabled {
+			enabledParams = append(enabledParams, entry)
+		}
+	}
+	return enabledStatic, enabledParams
+}
+
+// URLBuilder builds the url of an entry of route from the variables enumerated (none for static routes).
+// The default is route.URL(pairs...).
+type URLBuilder func(route *mux.Route, pairs ...string) (*url.URL, error)
+
+// SetURLBuilder sets the function building the urls of the route registered with pattern,
+// e.g. for vanity urls differing from the pattern of the route.
+func (r *Router) SetURLBuilder(pattern string, builder URLBuilder) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.URLBuilder = builder
+	})
+}
+
+// buildURL returns the url of route for pairs, built by s.URLBuilder if set, with s.PathPrefix.
+func (s *routeSettings) buildURL(route *mux.Route, pairs ...string) (*url.URL, error) {
+	var u *url.URL
+	var err error
+	if s.URLBuilder != nil {
+		u, err = s.URLBuilder(route, pairs...)
+	} else {
+		u, err = route.URL(pairs...)
+	}
+	if err != nil || s.PathPrefix == "" {
+		return u, err
+	}
+	u.Path = s.PathPrefix + u.Path
+	if u.RawPath != "" {
+		u.RawPath = s.PathPrefix + u.RawPath
+	}
+	return u, nil
+}
+
+// RouteError is the error of the url of an entry of a registered route, e.g. variables not matching the pattern.
+type RouteError struct {
+	Pattern string
+	Pairs   []string // variables given by the enumerator
+	Err     error
+}
+
+func (e *RouteError) Error() string {
+	if len(e.Pairs) == 0 {
+		return fmt.Sprintf("sitemap: route %s: %v", e.Pattern, e.Err)
+	}
+	return fmt.Sprintf("sitemap: route %s with variables %q: %v", e.Pattern, e.Pairs, e.Err)
+}
+
+// Unwrap returns the error of the url.
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+// ErrTooManyEntries is the error (in a RouteError) of a route enumerating more entries than allowed by SetMaxEntries().
+var ErrTooManyEntries = errors.New("too many entries")
+
+// SetMaxEntries fails the enumeration of the parameterized route registered with pattern beyond max entries (no limit if 0),
+// protecting the generation from enumerators looping forever.
+func (r *Router) SetMaxEntries(pattern string, max int) error {
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.MaxEntries = max
+	})
+}
+
+// limitEntries returns source, failing with ErrTooManyEntries after max entries.
+func limitEntries(pattern string, max int, source Source) Source {
+	return func(emit func(*Entry) error) error {
+		count := 0
+		return source(func(e *Entry) error {
+			count++
+			if count > max {
+				return &RouteError{Pattern: pattern, Err: ErrTooManyEntries}
+			}
+			return emit(e)
+		})
+	}
+}
+
+// conflict returns true if pattern is already registered in the sitemap, and records the conflict.
+// The caller must hold the routes lock.
+func (r *Router) conflict(pattern, method string) bool {
+	first := ""
+	for _, entry := range r.staticEntries {
+		if entry.Location == pattern {
+			first = "Register"
+		}
+	}
+	for _, entry := range r.paramEntries {
+		if entry.Pattern == pattern {
+			first = "RegisterParam"
+		}
+	}
+	if first == "" {
+		return false
+	}
+	r.conflicts = append(r.conflicts, fmt.Errorf("sitemap: %s(%q) ignored, the pattern is already registered with %s", method, pattern, first))
+	return true
+}
+
+// Conflicts returns the registrations left out of the sitemap because their pattern was already registered
+// (which would duplicate its entries), in order.
+func (r *Router) Conflicts() []error {
+	r.routesMutex.RLock()
+	defer r.routesMutex.RUnlock()
+	return append([]error(nil), r.conflicts...)
+}
@@ -0,0 +1,97 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Rule sets the crawl hints of the entries whose path matches Pattern
+// (a glob or a regular expression, as in Options.Include).
+type Rule struct {
+	Pattern         string          `json:"pattern"`
+	Priority        *float64        `json:"priority,omitempty"`
+	ChangeFrequency ChangeFrequency `json:"changefreq,omitempty"`
+	// Shard, if set, writes the entries into sitemap_<shard>_<n>.xml instead of the sitemaps of their route
+	// (lowercase letters, digits, hyphens and dots, except the reserved "recent" and "delta").
+	Shard   string `json:"shard,omitempty"`
+	Exclude bool   `json:"exclude,omitempty"` // leaves the entries out of the sitemaps
+}
+
+// LoadRules decodes rules from JSON (an array of rules), e.g. from a configuration file:
+//
+//	[
+//	  {"pattern": "/admin/**", "exclude": true},
+//	  {"pattern": "/blog/**", "priority": 0.8, "changefreq": "daily", "shard": "blog"}
+//	]
+func LoadRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	err := json.NewDecoder(r).Decode(&rules)
+	if err != nil {
+		return nil, fmt.Errorf("sitemap: invalid rules: %v", err)
+	}
+	_, err = compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// validShard matches the allowed shard names of rules, which become part of file names.
+var validShard = regexp.MustCompile(`^[a-z0-9.-]+$`)
+
+// ruleSet matches entries against compiled rules.
+type ruleSet struct {
+	rules    []Rule
+	matchers []*pathMatcher
+}
+
+// compileRules compiles and validates rules, nil if there is none.
+func compileRules(rules []Rule) (*ruleSet, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	set := &ruleSet{rules: rules}
+	for _, rule := range rules {
+		m, err := newPathMatcher([]string{rule.Pattern})
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case rule.Priority != nil && !(*rule.Priority >= 0 && *rule.Priority <= 1):
+			return nil, fmt.Errorf("sitemap: invalid priority %v in rule %q", *rule.Priority, rule.Pattern)
+		case rule.ChangeFrequency != "" && !rule.ChangeFrequency.Valid():
+			return nil, fmt.Errorf("sitemap: invalid change frequency %q in rule %q", string(rule.ChangeFrequency), rule.Pattern)
+		case rule.Shard != "" && (!validShard.MatchString(rule.Shard) || reservedName(rule.Shard)):
+			return nil, fmt.Errorf("sitemap: invalid shard %q in rule %q", rule.Shard, rule.Pattern)
+		}
+		set.matchers = append(set.matchers, m)
+	}
+	return set, nil
+}
+
+// match returns the first rule matching e, nil if none does.
+func (s *ruleSet) match(e *Entry) *Rule {
+	if s == nil {
+		return nil
+	}
+	path := matchedPath(e)
+	for i, m := range s.matchers {
+		if m.match(path) {
+			return &s.rules[i]
+		}
+	}
+	return nil
+}
+
+// apply sets the priority and the change frequency of rule on e.
+func (rule *Rule) apply(e *Entry) {
+	if rule.Priority != nil {
+		priority := *rule.Priority
+		e.Priority = &priority
+	}
+	if rule.ChangeFrequency != "" {
+		e.ChangeFrequency = rule.ChangeFrequency
+	}
+}
@@ -0,0 +1,90 @@
+package sitemap
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	pathpkg "path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// copyBuffers recycles the buffers used to send files, bounding the memory used per request whatever the file size.
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 32*1024)
+		return &b
+	},
+}
+
+// storageHandler serves the files of a storage, like http.StripPrefix(prefix, http.FileServer(storage))
+// but without reading ahead or seeking: files are streamed once from start to end through a small buffer,
+// which suits remote storages. Content types are derived from file extensions.
+//
+// Files whose size is unknown (negative in Stat) are sent with chunked transfer encoding.
+// Range requests are not supported: the whole file is always sent.
+// Requests for files which don't exist are passed to notFound (http.NotFound if nil).
+type storageHandler struct {
+	prefix   string
+	storage  Storage
+	notFound http.HandlerFunc
+}
+
+func (h *storageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r)
+}
+
+// serve serves the file of r from the storage, and returns its name (relative to the storage root)
+// if it exists, or false if the reply is not found.
+func (h *storageHandler) serve(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if !strings.HasPrefix(r.URL.Path, h.prefix) {
+		h.replyNotFound(w, r)
+		return "", false
+	}
+	name := pathpkg.Clean("/" + strings.TrimPrefix(r.URL.Path, h.prefix))
+	f, err := h.storage.Open(name)
+	if err != nil {
+		h.replyNotFound(w, r)
+		return "", false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		h.replyNotFound(w, r)
+		return "", false
+	}
+
+	if modTime := info.ModTime(); !modTime.IsZero() {
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modTime.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return strings.TrimPrefix(name, "/"), true
+		}
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	contentType := mime.TypeByExtension(pathpkg.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if size := info.Size(); size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if r.Method == "HEAD" {
+		return strings.TrimPrefix(name, "/"), true
+	}
+
+	buffer := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buffer)
+	io.CopyBuffer(w, f, *buffer)
+	return strings.TrimPrefix(name, "/"), true
+}
+
+func (h *storageHandler) replyNotFound(w http.ResponseWriter, r *http.Request) {
+	if h.notFound == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.notFound(w, r)
+}
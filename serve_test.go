@@ -0,0 +1,60 @@
+package sitemap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageHandler(t *testing.T) {
+	modified := time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)
+	storage := NewMemoryStorageWithClock(FixedClock(modified))
+	content := strings.Repeat("<url></url>", 10000)
+	err := storage.WriteFile("sitemap_1.xml", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &storageHandler{prefix: "/sitemaps/", storage: storage}
+
+	serve := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "http://example.com"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("GET", "/sitemaps/sitemap_1.xml", nil)
+	if w.Code != http.StatusOK || w.Body.String() != content || !strings.Contains(w.Header().Get("Content-Type"), "xml") {
+		t.Errorf("Unexpected response %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Content-Length") != "110000" {
+		t.Errorf("Unexpected Content-Length %s", w.Header().Get("Content-Length"))
+	}
+	if lastModified := w.Header().Get("Last-Modified"); lastModified != modified.Format(http.TimeFormat) {
+		t.Errorf("Expecting the time of the clock of the storage but got Last-Modified %s", lastModified)
+	}
+
+	w = serve("HEAD", "/sitemaps/sitemap_1.xml", nil)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("Unexpected HEAD response %d with %d bytes", w.Code, w.Body.Len())
+	}
+
+	since := http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}
+	if w = serve("GET", "/sitemaps/sitemap_1.xml", since); w.Code != http.StatusNotModified {
+		t.Errorf("Expecting 304 but got %d", w.Code)
+	}
+
+	for _, path := range []string{"/sitemaps/missing.xml", "/other/sitemap_1.xml"} {
+		if w = serve("GET", path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expecting 404 on %s but got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package sitemap
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const signature_file = "manifest.json.sig"
+
+// ErrInvalidSignature is returned by Verify if the manifest was not signed with the private key of the public key.
+var ErrInvalidSignature = errors.New("sitemap: invalid manifest signature")
+
+// writeSignature writes the base64-encoded signature of the manifest data with key into s.
+func writeSignature(data []byte, key ed25519.PrivateKey, s Storage) error {
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	return s.WriteFile(signature_file, []byte(sig+"\n"))
+}
+
+// Verify checks that the files in s are those of a generation signed with the private key of key (see Options.SigningKey):
+// the signature of manifest.json must be valid, and every file listed in the manifest must have the size and the hash
+// recorded in it. It is meant for mirrors and CDNs serving a copy of the sitemaps, to confirm the copy is untampered.
+//
+// Files written by later generations fail the verification until the manifest is replaced, as with any mirror.
+func Verify(s Storage, key ed25519.PublicKey) (*Manifest, error) {
+	data, err := readFile(s, manifest_file)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := readFile(s, signature_file)
+	if err != nil {
+		return nil, err
+	}
+	sig, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil || !ed25519.Verify(key, data, sig) {
+		return nil, ErrInvalidSignature
+	}
+	m, err := readManifest(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		content, err := readFile(s, f.Name)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		if int64(len(content)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, fmt.Errorf("sitemap: %s does not match the manifest", f.Name)
+		}
+	}
+	return m, nil
+}
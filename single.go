@@ -0,0 +1,39 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+)
+
+const single_sitemap_file = "sitemap.xml"
+
+// writeSingleSitemap writes sitemap.xml for Options.SingleSitemap: a copy of the only sitemap if there is a single one,
+// a copy of the index otherwise. It returns the description of the file.
+func writeSingleSitemap(s Storage, locations []string, index []byte) (*ManifestFile, error) {
+	data, urls := index, len(locations)
+	if len(locations) == 1 {
+		var err error
+		data, err = readFile(s, locations[0])
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(locations[0], gzip_extension) {
+			reader, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			data, err = ioutil.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+		urls = -1 // unknown here, set by the caller
+	}
+	err := s.WriteFile(single_sitemap_file, data)
+	if err != nil {
+		return nil, err
+	}
+	return newManifestFile(single_sitemap_file, data, urls), nil
+}
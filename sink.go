@@ -0,0 +1,81 @@
+package sitemap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// EntrySink receives the sitemaps of a Buffer, split to at most 50000 entries each (see NewSinkBuffer()).
+type EntrySink interface {
+	// WriteSitemap writes s, the n-th sitemap of the buffer (counting from 1).
+	// The sitemap is not modified by the buffer afterwards.
+	WriteSitemap(n int, s *Sitemap) error
+}
+
+// EntrySinkFunc is an EntrySink calling the function itself.
+type EntrySinkFunc func(n int, s *Sitemap) error
+
+// WriteSitemap calls f(n, s).
+func (f EntrySinkFunc) WriteSitemap(n int, s *Sitemap) error {
+	return f(n, s)
+}
+
+// encodingSink is an EntrySink encoding the sitemaps with the encoder of the buffer,
+// so that its XMLHeader, PriorityPrecision and OmitDefaultPriority apply.
+type encodingSink interface {
+	EntrySink
+	encodeSitemap(n int, s *Sitemap, encoder func(w io.Writer) *sitemapEncoder) error
+}
+
+// writerSink is the EntrySink of WriterSink.
+type writerSink struct {
+	w io.Writer
+}
+
+// WriterSink returns an EntrySink encoding each sitemap into w, one XML document after the other
+// (e.g. for a single sitemap on the standard output).
+func WriterSink(w io.Writer) EntrySink {
+	return writerSink{w: w}
+}
+
+func (s writerSink) WriteSitemap(n int, sm *Sitemap) error {
+	return encodeSitemap(s.w, sm)
+}
+
+func (s writerSink) encodeSitemap(n int, sm *Sitemap, encoder func(w io.Writer) *sitemapEncoder) error {
+	return encoder(s.w).encode(sm)
+}
+
+// storageSink is the EntrySink of StorageSink.
+type storageSink struct {
+	storage Storage
+	pattern string
+}
+
+// StorageSink returns an EntrySink writing the n-th sitemap into the file fmt.Sprintf(pattern, n) of s,
+// e.g. with pattern "sitemap_%d.xml".
+func StorageSink(s Storage, pattern string) EntrySink {
+	return storageSink{storage: s, pattern: pattern}
+}
+
+func (s storageSink) WriteSitemap(n int, sm *Sitemap) error {
+	return s.encodeSitemap(n, sm, new(Buffer).encoder)
+}
+
+func (s storageSink) encodeSitemap(n int, sm *Sitemap, encoder func(w io.Writer) *sitemapEncoder) error {
+	data := encodeBuffers.Get().(*bytes.Buffer)
+	data.Reset()
+	defer encodeBuffers.Put(data)
+	err := encoder(data).encode(sm)
+	if err != nil {
+		return err
+	}
+	return s.storage.WriteFile(fmt.Sprintf(s.pattern, n), data.Bytes())
+}
+
+// FileSink returns an EntrySink writing the n-th sitemap into the file fmt.Sprintf(pattern, n) of the directory dir,
+// created if needed.
+func FileSink(dir, pattern string) EntrySink {
+	return StorageSink(DirStorage(dir), pattern)
+}
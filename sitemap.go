@@ -2,14 +2,24 @@ package sitemap
 
 import (
 	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
 // Schema represents an XML schema.
+//
+// Schemas are shared by sitemaps: copy a schema to change it, e.g. to use it in Options.Schema.
 type Schema struct {
 	Xmlns             string `xml:"xmlns,attr"`
-	XmlnsXsi          string `xml:"xmlns:xsi,attr"`
-	XsiSchemaLocation string `xml:"xsi:schemaLocation,attr"`
+	XmlnsXsi          string `xml:"xmlns:xsi,attr,omitempty"`
+	XsiSchemaLocation string `xml:"xsi:schemaLocation,attr,omitempty"`
+	XmlnsXhtml        string `xml:"xmlns:xhtml,attr,omitempty"` // needed by alternates
+	// Extra are additional attributes, e.g. the namespaces of extensions.
+	// Prefixes are part of the local names: xml.Name{Local: "xmlns:image"}, without Space.
+	Extra []xml.Attr `xml:",any,attr"`
 }
 
 // SitemapSchema is the XML schema used for sitemaps.
@@ -24,14 +34,35 @@ type ChangeFrequency string
 
 const (
 	Always  ChangeFrequency = "always"
-	Hourly                  = "hourly"
-	Daily                   = "daily"
-	Weekly                  = "weekly"
-	Monthly                 = "monthly"
-	Yearly                  = "yearly"
-	Never                   = "never"
+	Hourly  ChangeFrequency = "hourly"
+	Daily   ChangeFrequency = "daily"
+	Weekly  ChangeFrequency = "weekly"
+	Monthly ChangeFrequency = "monthly"
+	Yearly  ChangeFrequency = "yearly"
+	Never   ChangeFrequency = "never"
 )
 
+// Valid reports whether f is one of the frequencies defined by the sitemap protocol.
+func (f ChangeFrequency) Valid() bool {
+	switch f {
+	case Always, Hourly, Daily, Weekly, Monthly, Yearly, Never:
+		return true
+	}
+	return false
+}
+
+// MarshalXML encodes f, or fails if it is not valid.
+func (f ChangeFrequency) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if !f.Valid() {
+		return invalidChangeFrequency(f)
+	}
+	return e.EncodeElement(string(f), start)
+}
+
+func invalidChangeFrequency(f ChangeFrequency) error {
+	return fmt.Errorf("sitemap: invalid change frequency %q", string(f))
+}
+
 // Sitemap is a sitemap, with xml-encoding attributes.
 //
 // Call NewSitemap() to get a new sitemap with the correct XML schema, ready to get encoded.
@@ -45,6 +76,91 @@ type Sitemap struct {
 type FileReference struct {
 	Location         string     `xml:"loc"`
 	LastModification *time.Time `xml:"lastmod,omitempty"` // optional
+	// LastModPrecision is the precision of LastModification in the generated files, Options.LastModPrecision if zero.
+	// It is ignored by encoding/xml.
+	LastModPrecision DatePrecision `xml:"-"`
+}
+
+// DatePrecision is the precision of the lastmod dates written in sitemaps and indexes.
+type DatePrecision int
+
+const (
+	NanosecondPrecision DatePrecision = iota // RFC 3339 with fractional seconds if any, as encoding/xml does
+	SecondPrecision                          // RFC 3339 without fractional seconds, e.g. 2006-01-02T15:04:05+01:00
+	DayPrecision                             // date only, e.g. 2006-01-02 (in the time zone of the date)
+)
+
+// layout returns the time layout of p.
+func (p DatePrecision) layout() string {
+	switch p {
+	case SecondPrecision:
+		return time.RFC3339
+	case DayPrecision:
+		return "2006-01-02"
+	}
+	return time.RFC3339Nano
+}
+
+// w3cLayouts are the layouts of the W3C datetime formats allowed for lastmod dates, with their precision.
+// Fractional seconds are accepted by time.Parse after the seconds of time.RFC3339.
+var w3cLayouts = []struct {
+	layout    string
+	precision DatePrecision
+}{
+	{time.RFC3339, SecondPrecision},
+	{"2006-01-02T15:04Z07:00", SecondPrecision},
+	{"2006-01-02", DayPrecision},
+	{"2006-01", DayPrecision},
+	{"2006", DayPrecision},
+}
+
+// parseLastMod parses a lastmod date in any of the W3C datetime formats, and returns it with its precision.
+func parseLastMod(s string) (*time.Time, DatePrecision, error) {
+	s = strings.TrimSpace(s)
+	for _, l := range w3cLayouts {
+		t, err := time.Parse(l.layout, s)
+		if err == nil {
+			if l.precision == SecondPrecision && strings.Contains(s, ".") {
+				return &t, NanosecondPrecision, nil
+			}
+			return &t, l.precision, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("sitemap: invalid lastmod %q", s)
+}
+
+// fileReferenceXML is the XML decoding of a FileReference, with the lastmod parsed by parseLastMod.
+type fileReferenceXML struct {
+	Location         string `xml:"loc"`
+	LastModification string `xml:"lastmod"`
+}
+
+// reference returns the file reference of x.
+func (x *fileReferenceXML) reference() (*FileReference, error) {
+	ref := &FileReference{Location: x.Location}
+	if x.LastModification != "" {
+		var err error
+		ref.LastModification, ref.LastModPrecision, err = parseLastMod(x.LastModification)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ref, nil
+}
+
+// UnmarshalXML decodes r, accepting the lastmod dates of every W3C datetime precision (e.g. 2006-01-02).
+func (r *FileReference) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var x fileReferenceXML
+	err := dec.DecodeElement(&x, &start)
+	if err != nil {
+		return err
+	}
+	ref, err := x.reference()
+	if err != nil {
+		return err
+	}
+	*r = *ref
+	return nil
 }
 
 // Entry is a sitemap entry (a url block in the XML file).
@@ -52,6 +168,113 @@ type Entry struct {
 	*FileReference
 	ChangeFrequency ChangeFrequency `xml:"changefreq,omitempty"` // optional
 	Priority        *float64        `xml:"priority,omitempty"`   // optional
+	Alternates      []*Alternate    `xml:"xhtml:link,omitempty"` // optional
+
+	// Metadata is opaque data about the entry, never written: sources can attach data for
+	// filters and other generation-time functions (e.g. the section or the author of the page).
+	Metadata map[string]interface{} `xml:"-" json:"-"`
+}
+
+// entryXML is the XML encoding of an Entry, with the priority formatted by appendPriority.
+type entryXML struct {
+	*FileReference
+	ChangeFrequency ChangeFrequency `xml:"changefreq,omitempty"`
+	Priority        string          `xml:"priority,omitempty"`
+	Alternates      []*Alternate    `xml:"xhtml:link,omitempty"`
+}
+
+// MarshalXML encodes e with its priority formatted by appendPriority, or fails if its priority or change frequency is not valid.
+func (e *Entry) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	x := entryXML{
+		FileReference:   e.FileReference,
+		ChangeFrequency: e.ChangeFrequency,
+		Alternates:      e.Alternates,
+	}
+	if e.Priority != nil {
+		priority, err := appendPriority(nil, *e.Priority)
+		if err != nil {
+			return err
+		}
+		x.Priority = string(priority)
+	}
+	return enc.EncodeElement(x, start)
+}
+
+// UnmarshalXML decodes e like FileReference.UnmarshalXML.
+func (e *Entry) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	var x struct {
+		fileReferenceXML
+		ChangeFrequency ChangeFrequency `xml:"changefreq"`
+		Priority        *float64        `xml:"priority"`
+		Alternates      []*Alternate    `xml:"xhtml:link"`
+	}
+	err := dec.DecodeElement(&x, &start)
+	if err != nil {
+		return err
+	}
+	ref, err := x.reference()
+	if err != nil {
+		return err
+	}
+	*e = Entry{
+		FileReference:   ref,
+		ChangeFrequency: x.ChangeFrequency,
+		Priority:        x.Priority,
+		Alternates:      x.Alternates,
+	}
+	return nil
+}
+
+// newEntry returns an entry for loc, allocating the entry and its file reference at once.
+func newEntry(loc string) *Entry {
+	block := new(struct {
+		entry Entry
+		ref   FileReference
+	})
+	block.ref.Location = loc
+	block.entry.FileReference = &block.ref
+	return &block.entry
+}
+
+// copyEntry returns a shallow copy of e, with a copy of its file reference, allocated at once.
+// Generations modify copies, so that the entries owned by sources (see EntrySource) are never changed.
+func copyEntry(e *Entry) *Entry {
+	if e.FileReference == nil {
+		c := *e
+		return &c
+	}
+	block := new(struct {
+		entry Entry
+		ref   FileReference
+	})
+	block.entry, block.ref = *e, *e.FileReference
+	block.entry.FileReference = &block.ref
+	return &block.entry
+}
+
+// pooledEntries recycles the entries copied by the generations which don't keep them once written (see generation.recycle),
+// so that large generations don't allocate an entry per url.
+var pooledEntries = sync.Pool{
+	New: func() interface{} {
+		return newEntry("")
+	},
+}
+
+// pooledCopy returns a copy of e like copyEntry, taken from pooledEntries. e must have a file reference.
+func pooledCopy(e *Entry) *Entry {
+	c := pooledEntries.Get().(*Entry)
+	ref := c.FileReference
+	*c, *ref = *e, *e.FileReference
+	c.FileReference = ref
+	return c
+}
+
+// releaseEntry clears e, returned by pooledCopy, and puts it back into pooledEntries.
+func releaseEntry(e *Entry) {
+	ref := e.FileReference
+	*ref = FileReference{}
+	*e = Entry{FileReference: ref}
+	pooledEntries.Put(e)
 }
 
 // NewSitemap creates an empty sitemap with the schema set as SitemapSchema.
@@ -71,10 +294,16 @@ func (s *Sitemap) IsFull() bool {
 	if s == nil {
 		return false
 	}
-	return len(s.Entries) >= 50000
+	return len(s.Entries) >= max_sitemap_urls
 }
 
 // WriteToFile encodes the sitemap in XML format into path.
 func (s *Sitemap) WriteToFile(path string) error {
-	return writeToFileXML(s, path)
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return encodeSitemap(out, s)
 }
@@ -2,7 +2,6 @@ package sitemap
 
 import (
 	"net/http"
-	"os"
 )
 
 // sitemapHandler handles the requests to sitemaps.
@@ -10,38 +9,56 @@ import (
 // The sitemaps are created automatically on the first request.
 type sitemapHandler struct {
 	router      *Router
-	fileHandler http.Handler
+	options     *Options // options fileHandler was created with
+	fileHandler *storageHandler
 }
 
-// ServeHTTP serves the sitemapindex and the sitemaps from disk.
-// It generates the files if they don't exist.
+// ServeHTTP serves the sitemapindex and the sitemaps from the storage.
+// It generates the files if they don't exist, and replies 503 if that fails (or if they don't exist in read-only mode).
 func (sh *sitemapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	mutex := sh.router.sitemapMutex
+	mutex := &sh.router.sitemapMutex
+	options := sh.router.deriveDomain(r)
 	mutex.RLock()
 	defer mutex.RUnlock()
 
-	if sh.fileHandler == nil {
+	miss, start := false, options.now()
+	var err error // error of the generation made for the request, if any
+	if sh.fileHandler == nil || sh.options != options {
 		mutex.RUnlock()
 		mutex.Lock()
 
-		if sh.fileHandler == nil {
+		if sh.fileHandler == nil || sh.options != options {
 			// check if sitemap index file exists
-			_, err := os.Open(sh.router.Options.CachePath + "sitemapindex.xml")
-			if err != nil {
-				os.MkdirAll(sh.router.Options.CachePath, os.ModeDir|os.ModePerm)
-				_, err = sh.router.GenerateSitemaps()
-				if err != nil {
-					panic(err)
+			exists := fileExists(options.storage(), options.indexFile())
+			if !exists && !options.ReadOnly {
+				miss = true
+				if opts := requestOptions(options, r); opts.Domain == "" {
+					err = errNoDomain
+				} else {
+					_, err = sh.router.generateSitemaps(opts)
 				}
 			}
-			sh.fileHandler = http.StripPrefix(sh.router.Options.ServerPath,
-				http.FileServer(http.Dir(sh.router.Options.CachePath)))
+			if err == nil && (exists || !options.ReadOnly) {
+				sh.options = options
+				sh.fileHandler = &storageHandler{prefix: options.serverPath(), storage: options.storage(), notFound: sh.router.notFound}
+			}
 		}
 
 		mutex.Unlock()
+		sh.router.notifications.flush()
 		mutex.RLock()
 	}
-	if sh.fileHandler != nil {
-		sh.fileHandler.ServeHTTP(w, r)
+	if sh.fileHandler == nil {
+		// the generation failed (see Stats().LastError), or the files don't exist yet in read-only mode:
+		// try again on the next request
+		http.Error(w, "sitemaps unavailable", http.StatusServiceUnavailable)
+		options.Events.publish(ServeError{Path: r.URL.Path, Err: err})
+		return
+	}
+	if options.TracingHeaders {
+		setTracingHeaders(w, r, options, miss, options.now().Sub(start), sh.router.stats.get().LastGeneration)
+	}
+	if file, served := sh.fileHandler.serve(w, r); served {
+		sh.router.fetches.record(file, r.UserAgent(), options.now(), options.storage())
 	}
 }
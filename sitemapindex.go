@@ -2,7 +2,7 @@ package sitemap
 
 import (
 	"encoding/xml"
-	"html"
+	"io"
 	"os"
 )
 
@@ -24,30 +24,30 @@ var SitemapIndexSchema = &Schema{
 func NewSitemapIndex(sitemapUrls []string) *SitemapIndex {
 	refs := make([]*FileReference, len(sitemapUrls), len(sitemapUrls))
 	for i, loc := range sitemapUrls {
-		refs[i] = &FileReference{Location: html.EscapeString(loc)}
+		refs[i] = &FileReference{Location: EscapeLoc(loc)}
 	}
 	return &SitemapIndex{SitemapRefs: refs, Schema: SitemapIndexSchema}
 }
 
 // WriteToFile writes the sitemap index in XML into path.
 func (s *SitemapIndex) WriteToFile(path string) error {
-	return writeToFileXML(s, path)
-}
-
-// writeToFileXML writes the given data into outFileName, using the encoding/xml.
-func writeToFileXML(data interface{}, outFileName string) error {
-	out, err := os.Create(outFileName)
+	out, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	_, err = out.Write([]byte(xml.Header))
+	return encodeSitemapIndex(out, s)
+}
+
+// encodeXML writes the XML header and the indented encoding of data to w.
+func encodeXML(w io.Writer, data interface{}) error {
+	_, err := w.Write([]byte(xml.Header))
 	if err != nil {
 		return err
 	}
 
-	encoder := xml.NewEncoder(out)
+	encoder := xml.NewEncoder(w)
 	encoder.Indent("", "  ")
 
 	return encoder.Encode(data)
@@ -0,0 +1,116 @@
+package sitemap
+
+import (
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	linkTagRegexp  = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+	metaTagRegexp  = regexp.MustCompile(`(?is)<meta\b[^>]*>`)
+	attributeRegex = regexp.MustCompile(`(?s)([\w-]+)\s*=\s*("[^"]*"|'[^']*'|[^\s>]+)`)
+)
+
+// HTMLDirSource returns a Source yielding one entry per HTML page found in root,
+// typically the output directory of a static site generator (Hugo, Next export...).
+//
+// The location of a page is its canonical url (<link rel="canonical" href="...">), resolved against domain.
+// Without canonical link, it is derived from the file path: root/a/b.html is domain/a/b.html,
+// and root/a/index.html (or index.htm) is domain/a/. The file names are escaped, e.g. "100%.html" is domain/100%25.html.
+// Pages with a robots meta tag containing "noindex" are skipped.
+func HTMLDirSource(root, domain string) Source {
+	return func(emit func(*Entry) error) error {
+		return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !isHTMLFile(file) {
+				return nil
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			loc, ok, err := pageLocation(domain, filepath.ToSlash(rel), content)
+			if err != nil || !ok {
+				return err
+			}
+			return emit(&Entry{
+				FileReference: &FileReference{Location: loc},
+			})
+		})
+	}
+}
+
+func isHTMLFile(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".html" || ext == ".htm"
+}
+
+// pageLocation returns the full url of the page with the given content, served at relPath.
+// It returns false if the page must not appear in the sitemap.
+func pageLocation(domain, relPath string, content []byte) (string, bool, error) {
+	for _, tag := range metaTagRegexp.FindAll(content, -1) {
+		attrs := tagAttributes(tag)
+		if strings.EqualFold(attrs["name"], "robots") && strings.Contains(strings.ToLower(attrs["content"]), "noindex") {
+			return "", false, nil
+		}
+	}
+
+	if index := pathpkg.Base(relPath); index == "index.html" || index == "index.htm" {
+		relPath = strings.TrimSuffix(relPath, index)
+	}
+	base, err := url.Parse(strings.TrimSuffix(domain, "/"))
+	if err != nil {
+		return "", false, err
+	}
+	// a file path is not a url: build the url from the unescaped path, so that "%" or "?" in names get escaped
+	base.Path += "/" + relPath
+	base.RawPath = ""
+
+	for _, tag := range linkTagRegexp.FindAll(content, -1) {
+		attrs := tagAttributes(tag)
+		if !hasToken(attrs["rel"], "canonical") || attrs["href"] == "" {
+			continue
+		}
+		canonical, err := base.Parse(attrs["href"])
+		if err != nil {
+			return "", false, err
+		}
+		return canonical.String(), true, nil
+	}
+	return base.String(), true, nil
+}
+
+// tagAttributes returns the (lower-case) attributes of an html tag, with unescaped values.
+func tagAttributes(tag []byte) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attributeRegex.FindAllSubmatch(tag, -1) {
+		value := string(m[2])
+		if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, `'`) {
+			value = value[1 : len(value)-1]
+		}
+		attrs[strings.ToLower(string(m[1]))] = html.UnescapeString(value)
+	}
+	return attrs
+}
+
+// hasToken returns true if the space-separated list contains token (case-insensitive).
+func hasToken(list, token string) bool {
+	for _, t := range strings.Fields(list) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package sitemap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHTMLDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pages := map[string]string{
+		"index.html":          `<html><head><title>Home</title></head></html>`,
+		"about/index.html":    `<html><head></head></html>`,
+		"posts/first.html":    `<html><head><link href="/posts/first/" rel="canonical"></head></html>`,
+		"drafts/secret.html":  `<html><head><meta name="robots" content="noindex, nofollow"></head></html>`,
+		"static/style.css":    `body {}`,
+		"posts/amp/first.htm": `<link rel='amphtml canonical' href='https://example.com/posts/first/?amp=1&amp;x=2'>`,
+		"legacy/index.htm":    `<html><head></head></html>`,
+		"sales/100%.html":     `<html><head><link rel="canonical" href="off%25.html"></head></html>`,
+		"sales/50% off.html":  `<html><head></head></html>`,
+	}
+	for name, content := range pages {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(file), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(file, []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var locations []string
+	err = HTMLDirSource(dir, "https://example.com")(func(e *Entry) error {
+		locations = append(locations, e.Location)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"https://example.com/about/",
+		"https://example.com/",
+		"https://example.com/legacy/",
+		"https://example.com/posts/first/?amp=1&x=2",
+		"https://example.com/posts/first/",
+		"https://example.com/sales/off%25.html",
+		"https://example.com/sales/50%25%20off.html",
+	}
+	if len(locations) != len(expected) {
+		t.Fatalf("Expecting %v but got %v", expected, locations)
+	}
+	for i := range expected {
+		if locations[i] != expected[i] {
+			t.Errorf("Expecting %s but got %s", expected[i], locations[i])
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package sitemap
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats reports the generations of a Router.
+type Stats struct {
+	Generations    int           // number of generations attempted
+	Failures       int           // number of failed generations
+	LastAttempt    time.Time     // start of the last generation, successful or not
+	LastGeneration time.Time     // time of the last successful generation
+	LastDuration   time.Duration // duration of the last successful generation
+	URLs           int           // number of entries in the last successful generation
+	DroppedURLs    int           // number of entries beyond Options.MaxURLs, left out of the last successful generation
+	Files          int           // number of files written by the last successful generation (manifest excluded)
+	Bytes          int64         // total size of these files
+	Shards         []ShardStats  // sizes of the shards of the last successful generation (see Manifest.Shards)
+	LastError      error         // error of the last generation, nil if it succeeded
+	History        []Generation  // last generations (at most HistorySize), oldest first
+
+	// ClampedLastMods is the number of entries of the last successful generation whose lastmod was in the future
+	// (see Options.ClampLastMod): a sign of clock skew or bad data in the sources.
+	ClampedLastMods int
+	// RejectedURLs is the number of invalid entries left out of the last successful generation (see Options.RejectInvalid).
+	RejectedURLs int
+	// RetainedURLs is the number of entries of the last successful generation kept although their source
+	// no longer emits them (see Options.GracePeriod): a sudden rise is a sign of a failing data source.
+	RetainedURLs int
+
+	// Routes are the statistics of the routes in the last generation of GenerateSitemaps() (or lazy generation),
+	// successful or not, in the order of Routes().
+	Routes []RouteStats
+}
+
+// RouteStats are the statistics of a route in a generation.
+// Routes not enumerated (left out by Options.OnlyTags, or after the failure of another route) have zero values.
+type RouteStats struct {
+	Pattern  string
+	URLs     int           // number of entries enumerated, before filters and options
+	Duration time.Duration // time spent in the enumeration, including the processing of the entries
+	Error    error         // error of the enumeration, if any
+}
+
+// measureSource returns a Source calling source and recording its statistics into stats.
+func measureSource(source Source, stats *RouteStats, opts *Options) Source {
+	return func(emit func(*Entry) error) error {
+		start := opts.now()
+		err := source(func(e *Entry) error {
+			stats.URLs++
+			return emit(e)
+		})
+		stats.Duration += opts.now().Sub(start)
+		stats.Error = err
+		return err
+	}
+}
+
+// Generation summarizes a generation.
+type Generation struct {
+	Start    time.Time
+	Duration time.Duration
+	URLs     int
+	Files    int
+	Bytes    int64
+	Error    error // nil if successful
+}
+
+// HistorySize is the number of generations kept in Stats.History.
+const HistorySize = 100
+
+// Stale returns true if the last generation failed while a previous one succeeded:
+// the sitemaps of the previous generation are still served.
+func (s Stats) Stale() bool {
+	return s.LastError != nil && s.Generations > s.Failures
+}
+
+// statsRecorder records Stats safely.
+type statsRecorder struct {
+	mutex sync.Mutex
+	stats Stats
+}
+
+// record updates the stats with the result of a generation started at start.
+func (s *statsRecorder) record(m *Manifest, start time.Time, duration time.Duration, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Generations++
+	s.stats.LastAttempt = start
+	s.stats.LastError = err
+	generation := Generation{Start: start, Duration: duration, Error: err}
+	if err != nil {
+		s.stats.Failures++
+	} else {
+		s.stats.LastGeneration = m.Generated
+		s.stats.LastDuration = duration
+		s.stats.URLs = m.URLs
+		s.stats.DroppedURLs = m.DroppedURLs
+		s.stats.ClampedLastMods = m.ClampedLastMods
+		s.stats.RejectedURLs = m.RejectedURLs
+		s.stats.RetainedURLs = m.RetainedURLs
+		s.stats.Files = len(m.Files)
+		s.stats.Shards = m.Shards
+		s.stats.Bytes = 0
+		for _, f := range m.Files {
+			s.stats.Bytes += f.Size
+		}
+		generation.URLs, generation.Files, generation.Bytes = s.stats.URLs, s.stats.Files, s.stats.Bytes
+	}
+
+	if len(s.stats.History) == HistorySize {
+		s.stats.History = s.stats.History[1:]
+	}
+	s.stats.History = append(s.stats.History, generation)
+}
+
+// recordRoutes records the statistics of the routes of a generation.
+func (s *statsRecorder) recordRoutes(routes []RouteStats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stats.Routes = routes
+}
+
+// get returns a copy of the stats.
+func (s *statsRecorder) get() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := s.stats
+	stats.History = append([]Generation(nil), s.stats.History...)
+	return stats
+}
+
+// Fetches reports the requests on a sitemap file.
+type Fetches struct {
+	File          string
+	Count         int
+	Last          time.Time
+	LastUserAgent string
+}
+
+// max_fetched_files is the maximum number of files whose fetches are recorded.
+const max_fetched_files = 1000
+
+// fetchRecorder records the requests on sitemap files.
+type fetchRecorder struct {
+	mutex   sync.Mutex
+	fetches map[string]*Fetches
+	files   map[string]bool // files of the current manifest, read on the first record after reset
+}
+
+// record records a request on file, served from s by userAgent. Only the files of the current manifest are recorded,
+// and at most max_fetched_files of them, so that requests on arbitrary names can't grow the records.
+func (f *fetchRecorder) record(file, userAgent string, now time.Time, s Storage) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.files == nil {
+		m, err := readManifest(s)
+		if err != nil {
+			return
+		}
+		f.files = make(map[string]bool, len(m.Files)+1)
+		for _, name := range m.fileNames() {
+			f.files[name] = true
+		}
+	}
+	if !f.files[file] {
+		return
+	}
+	if f.fetches == nil {
+		f.fetches = make(map[string]*Fetches)
+	}
+	fetches := f.fetches[file]
+	if fetches == nil {
+		if len(f.fetches) >= max_fetched_files {
+			return
+		}
+		fetches = &Fetches{File: file}
+		f.fetches[file] = fetches
+	}
+	fetches.Count++
+	fetches.Last = now
+	fetches.LastUserAgent = userAgent
+}
+
+// reset forgets the files of the manifest, after a generation replaced it.
+func (f *fetchRecorder) reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.files = nil
+}
+
+// get returns a copy of the fetches, sorted by file name.
+func (f *fetchRecorder) get() []Fetches {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fetches := make([]Fetches, 0, len(f.fetches))
+	for _, fetch := range f.fetches {
+		fetches = append(fetches, *fetch)
+	}
+	sort.Slice(fetches, func(i, j int) bool {
+		return fetches[i].File < fetches[j].File
+	})
+	return fetches
+}
+
+// Fetches returns the requests served so far on each sitemap file, sorted by file name.
+func (r *Router) Fetches() []Fetches {
+	return r.fetches.get()
+}
+
+// Stats returns the statistics of the generations of r.
+func (r *Router) Stats() Stats {
+	return r.stats.get()
+}
@@ -0,0 +1,138 @@
+package sitemap
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage stores the generated files.
+// It is an http.FileSystem, so that the files can be served directly from it.
+//
+// Generations write the sitemaps first, and the index and the manifest last. Sitemaps named after their content
+// (Options.HashFileNames) never replace the files of the previous generation; the others are written into staging/
+// when a previous index exists, and copied over the previous ones once every sitemap is written. So if a generation fails, the previous index
+// still references the complete sitemaps of the previous generation, as long as WriteFile is atomic.
+type Storage interface {
+	http.FileSystem
+	// WriteFile creates or replaces the file name (slash-separated, relative to the storage root) with data.
+	// data must not be retained after WriteFile returns.
+	WriteFile(name string, data []byte) error
+}
+
+// DirStorage is a Storage in a local directory, created when the first file is written.
+type DirStorage string
+
+// Open opens the file name as http.Dir does.
+func (d DirStorage) Open(name string) (http.File, error) {
+	return http.Dir(d).Open(name)
+}
+
+// WriteFile writes data to the file name in the directory.
+// The file is replaced atomically: readers get either the previous or the new content, never a partial file.
+func (d DirStorage) WriteFile(name string, data []byte) error {
+	file := filepath.Join(string(d), filepath.FromSlash(pathpkg.Clean("/"+name)))
+	dir := filepath.Dir(file)
+	err := os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// prefixStorage stores the files of a Storage under a prefix.
+type prefixStorage struct {
+	storage Storage
+	prefix  string
+}
+
+// PrefixStorage returns a Storage writing and reading all files in s under prefix,
+// so that several sites can share a storage without colliding.
+func PrefixStorage(s Storage, prefix string) Storage {
+	return &prefixStorage{
+		storage: s,
+		prefix:  "/" + strings.Trim(pathpkg.Clean("/"+prefix), "/"),
+	}
+}
+
+func (p *prefixStorage) Open(name string) (http.File, error) {
+	return p.storage.Open(p.prefix + pathpkg.Clean("/"+name))
+}
+
+func (p *prefixStorage) WriteFile(name string, data []byte) error {
+	return p.storage.WriteFile(p.prefix+pathpkg.Clean("/"+name), data)
+}
+
+// readFile returns the content of the file name in s.
+func readFile(s Storage, name string) ([]byte, error) {
+	f, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// fileExists returns true if the file name exists in s.
+func fileExists(s Storage, name string) bool {
+	f, err := s.Open(name)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// staging_dir is the directory where generations write the sitemaps with fixed names, until they are published.
+const staging_dir = "staging/"
+
+// stagingStorage writes files into staging_dir of a Storage, to publish them once every file is written, see Storage.
+type stagingStorage struct {
+	Storage
+	names []string // files written, not published yet
+}
+
+func (s *stagingStorage) WriteFile(name string, data []byte) error {
+	err := s.Storage.WriteFile(staging_dir+name, data)
+	if err != nil {
+		return err
+	}
+	s.names = append(s.names, name)
+	return nil
+}
+
+// publish copies the written files to their names, one at a time.
+func (s *stagingStorage) publish() error {
+	for _, name := range s.names {
+		data, err := readFile(s.Storage, staging_dir+name)
+		if err != nil {
+			return err
+		}
+		err = s.Storage.WriteFile(name, data)
+		if err != nil {
+			return err
+		}
+	}
+	s.names = nil
+	return nil
+}
@@ -0,0 +1,79 @@
+package sitemap
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamingHandler generates the sitemaps in memory, see Router.StreamingHandler().
+type streamingHandler struct {
+	router   *Router
+	cacheFor time.Duration
+
+	mutex       sync.Mutex
+	options     *Options  // options the files were generated with
+	site        string    // domain and external prefix the files were generated for, see requestOptions
+	generated   time.Time // time of the generation of the files
+	fileHandler http.Handler
+}
+
+// StreamingHandler returns an http.Handler serving sitemaps generated from the registered routes for each request,
+// without any cache on disk (Options.CachePath and Options.Storage are ignored), e.g. for small and very dynamic sites.
+// The files are generated in memory, and reused by the requests within cacheFor (0 to generate on every request)
+// for the same domain (which may be derived from the requests, see Options.AllowedHosts).
+// Generations take the lock of the sitemaps like GenerateSitemaps(), as they share the state of the router
+// (Options.ChangeTracker, Options.Delta, Options.GracePeriod, circuit breaker, stats).
+// It expects to serve r.Options.ServerPath + sitemapRoute(r.Options), see HandleStreamingSitemaps().
+//
+// Generation errors are reported in r.Stats(), and reply 503.
+func (r *Router) StreamingHandler(cacheFor time.Duration) http.Handler {
+	return &streamingHandler{
+		router:   r,
+		cacheFor: cacheFor,
+	}
+}
+
+// HandleStreamingSitemaps registers the routes of HandleSitemaps(), served by r.StreamingHandler(cacheFor).
+// The http handler is returned.
+func (r *Router) HandleStreamingSitemaps(cacheFor time.Duration) http.Handler {
+	handler := r.StreamingHandler(cacheFor)
+	r.handleFiles(handler, sitemapRoutes)
+	return handler
+}
+
+func (sh *streamingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	options := sh.router.deriveDomain(r)
+	sh.mutex.Lock()
+	fileHandler := sh.fileHandler
+	miss, start := false, options.now()
+	opts := *requestOptions(options, r)
+	site := opts.Domain + opts.ExternalPrefix
+	if fileHandler == nil || sh.options != options || sh.site != site || options.now().Sub(sh.generated) >= sh.cacheFor {
+		opts.Storage = NewMemoryStorageWithClock(opts.clock())
+		miss = true
+		err := errNoDomain
+		if opts.Domain != "" {
+			sh.router.sitemapMutex.Lock()
+			_, err = sh.router.generateSitemaps(&opts)
+			sh.router.sitemapMutex.Unlock()
+		}
+		if err != nil {
+			sh.mutex.Unlock()
+			sh.router.notifications.flush()
+			http.Error(w, "sitemaps unavailable", http.StatusServiceUnavailable)
+			options.Events.publish(ServeError{Path: r.URL.Path, Err: err})
+			return
+		}
+		fileHandler = &storageHandler{prefix: options.serverPath(), storage: opts.Storage, notFound: sh.router.notFound}
+		sh.options, sh.site, sh.generated, sh.fileHandler = options, site, options.now(), fileHandler
+	}
+	generated := sh.generated
+	sh.mutex.Unlock()
+	sh.router.notifications.flush()
+
+	if options.TracingHeaders {
+		setTracingHeaders(w, r, options, miss, options.now().Sub(start), generated)
+	}
+	fileHandler.ServeHTTP(w, r)
+}
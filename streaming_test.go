@@ -0,0 +1,90 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// testClock is a Clock whose time is set by tests.
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func TestStreamingHandler(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	start := time.Date(2014, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := &testClock{now: start}
+	r.Options.Clock = clock
+	var ids []string
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		for _, id := range ids {
+			err := cb("id", id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	handler := r.HandleStreamingSitemaps(time.Minute)
+
+	for _, test := range []struct {
+		ids      []string
+		after    time.Duration
+		expected int
+	}{
+		{[]string{"a"}, 0, 1},
+		{[]string{"a", "b"}, 30 * time.Second, 1}, // cached
+		{[]string{"a", "b"}, time.Minute, 2},
+	} {
+		ids = test.ids
+		clock.now = start.Add(test.after)
+		index := new(SitemapIndex)
+		mustServeXML(handler, "http://example.com/sitemapindex.xml", index, t)
+		sm := new(Sitemap)
+		mustServeXML(handler, index.SitemapRefs[0].Location, sm, t)
+		if len(sm.Entries) != test.expected {
+			t.Errorf("Expecting %d entries after %v but got %d", test.expected, test.after, len(sm.Entries))
+		}
+	}
+}
+
+func TestStreamingHandlerDomains(t *testing.T) {
+	r := NewRouter(mux.NewRouter(), "http://example.com", "unused")
+	r.Options.TrustForwardedHost = true
+	r.Options.AllowedHosts = []string{"*.example.com"}
+	locked := true
+	r.RegisterParam("/doc/{id}", func(cb func(...string) error) error {
+		if r.sitemapMutex.TryLock() {
+			r.sitemapMutex.Unlock()
+			locked = false
+		}
+		return cb("id", "a")
+	})
+	handler := r.StreamingHandler(time.Minute)
+
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		req := httptest.NewRequest("GET", "http://example.com/sitemapindex.xml", nil)
+		req.Header.Set("X-Forwarded-Host", host)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		index := new(SitemapIndex)
+		if err := xml.Unmarshal(w.Body.Bytes(), index); err != nil {
+			t.Fatalf("Invalid index %s: %v", w.Body.String(), err)
+		}
+		if len(index.SitemapRefs) != 1 || !strings.HasPrefix(index.SitemapRefs[0].Location, "http://"+host+"/") {
+			t.Errorf("Expecting the sitemaps of %s, got %v", host, index.SitemapRefs)
+		}
+	}
+	if !locked {
+		t.Error("Expecting the streaming generations to hold the lock of the sitemaps")
+	}
+}
@@ -0,0 +1,103 @@
+package sitemap
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// validTag matches the allowed tags, which become part of file names.
+var validTag = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// reservedName returns true if name is the name of the sitemaps of an option (see Options.RecentWindow and Options.Delta),
+// which can't be the name of a shard.
+func reservedName(name string) bool {
+	return name == recent_sitemap_name || name == delta_sitemap_name
+}
+
+// Tag adds tags (e.g. a locale, a content type or a team) to the route registered with pattern.
+// Tags must be made of lowercase letters, digits and hyphens, and can't be "recent" nor "delta" (reserved).
+//
+// The entries of routes with the same set of tags are written into their own sitemaps,
+// named sitemap_<tags>_<n>.xml where <tags> are the sorted tags joined with dots (e.g. sitemap_blog.en_1.xml).
+// Entries of untagged routes are written into sitemap_<n>.xml as usual. See also Options.OnlyTags.
+func (r *Router) Tag(pattern string, tags ...string) error {
+	for _, tag := range tags {
+		if !validTag.MatchString(tag) || reservedName(tag) {
+			return fmt.Errorf("sitemap: invalid tag %q", tag)
+		}
+	}
+
+	return r.updateRoute(pattern, func(s *routeSettings) {
+		s.Tags = addTags(s.Tags, tags)
+	})
+}
+
+// addTags returns a new sorted set of the tags of both slices.
+func addTags(tags, added []string) []string {
+	set := make(map[string]bool, len(tags)+len(added))
+	for _, tag := range tags {
+		set[tag] = true
+	}
+	for _, tag := range added {
+		set[tag] = true
+	}
+	result := make([]string, 0, len(set))
+	for tag := range set {
+		result = append(result, tag)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// hasAnyTag returns true if tags and wanted have a tag in common.
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if tag == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shard is a set of sources written into the same sitemaps.
+type shard struct {
+	name     string // tags of the sources joined with dots, empty for untagged sources
+	sources  []Source
+	patterns []string // patterns of the routes of the sources, if any
+}
+
+// key returns the key of the source at index i, identifying it across generations: its route pattern if any,
+// its index otherwise.
+func (s *shard) key(i int) string {
+	if i < len(s.patterns) {
+		return s.patterns[i]
+	}
+	return strconv.Itoa(i)
+}
+
+// shardSources groups the sources of routes by tags, in the order of the first route of each group.
+// Routes without any of opts.OnlyTags are left out, if set.
+func shardSources(sources []Source, routes []RouteInfo, opts *Options) []*shard {
+	var shards []*shard
+	byName := make(map[string]*shard)
+	for i, route := range routes {
+		if len(opts.OnlyTags) > 0 && !hasAnyTag(route.Tags, opts.OnlyTags) {
+			continue
+		}
+		name := strings.Join(route.Tags, ".")
+		s := byName[name]
+		if s == nil {
+			s = &shard{name: name}
+			byName[name] = s
+			shards = append(shards, s)
+		}
+		s.sources = append(s.sources, sources[i])
+		s.patterns = append(s.patterns, route.Pattern)
+	}
+	return shards
+}
@@ -0,0 +1,30 @@
+package sitemap
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setTracingHeaders sets the headers of Options.TracingHeaders on the reply to req: Server-Timing with the cache
+// status, the duration of the generation made for req if any (miss), and the age of the files generated at generated,
+// and Traceresponse with the W3C trace context of req (its traceparent header), if any.
+func setTracingHeaders(w http.ResponseWriter, req *http.Request, opts *Options, miss bool, generation time.Duration, generated time.Time) {
+	timing := []string{`cache;desc="hit"`}
+	if miss {
+		timing = []string{`cache;desc="miss"`, "gen;dur=" + milliseconds(generation)}
+	}
+	if !generated.IsZero() {
+		timing = append(timing, "age;dur="+milliseconds(opts.now().Sub(generated)))
+	}
+	w.Header().Set("Server-Timing", strings.Join(timing, ", "))
+	if traceparent := req.Header.Get("Traceparent"); traceparent != "" {
+		w.Header().Set("Traceresponse", traceparent)
+	}
+}
+
+// milliseconds formats d in milliseconds, as in Server-Timing durations.
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
@@ -0,0 +1,54 @@
+package sitemap
+
+import (
+	"context"
+)
+
+// Warmup generates the sitemaps if they don't exist in the storage, or if they are older than Options.RefreshInterval
+// (when set), so that no request has to wait for a lazy generation. Call it before serving, e.g.:
+//
+//	err := r.Warmup(ctx)
+//	if err != nil {
+//	  log.Print(err) // sitemaps will be generated on the first request
+//	}
+//	http.ListenAndServe(addr, r)
+//
+// Warmup returns ctx.Err() if ctx is done before the generation ends; the generation goes on in the background.
+func (r *Router) Warmup(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		defer r.notifications.flush()
+		r.sitemapMutex.Lock()
+		defer r.sitemapMutex.Unlock()
+		opts := r.options()
+		if r.fresh(opts) {
+			done <- nil
+			return
+		}
+		_, err := r.generateSitemaps(opts)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fresh returns true if the storage of opts has sitemaps, generated less than opts.RefreshInterval ago if set.
+func (r *Router) fresh(opts *Options) bool {
+	storage := opts.storage()
+	if !fileExists(storage, opts.indexFile()) {
+		return false
+	}
+	if opts.RefreshInterval <= 0 {
+		return true
+	}
+	m, err := readManifest(storage)
+	if err != nil {
+		return false
+	}
+	return opts.now().Sub(m.Generated) < opts.RefreshInterval
+}